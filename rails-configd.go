@@ -11,9 +11,12 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/coreos/go-etcd/etcd"
 	"github.com/rubenfonseca/rails-configd/src"
 )
 
@@ -22,6 +25,9 @@ This is a tool for watching over etcd tree, create config files for Rails, and r
 
 Usage: %s [options]
 
+Every option can also be set with an environment variable named after it, like RAILS_CONFIGD_ETCD_DIR for
+-etcd-dir, or in the -config file. The command line wins over the environment, which wins over the file.
+
 The following options are recognized:
 `
 
@@ -31,78 +37,474 @@ func usage() {
 	os.Exit(2)
 }
 
-func loop(receiverChannel chan *etcd.Response, env src.Env) {
-	for response := range receiverChannel {
-		key := env.NakedKey(response.Node.Key, *env.EtcdDir)
-		parts := strings.Split(key, "/")
-		env.UpdateData(parts, response.Node.Value, response.Action, env.Data)
-
-		log.Printf("[CHANGE]: %s %s %s", response.Action, key, response.Node.Value)
-
-		env.Cycle()
-	}
-}
-
 func main() {
 	env := src.Env{}
 	env.Data = make(map[string]interface{})
+	env.Status = new(src.Status)
 
-	env.Etcd = flag.String("etcd", "http://localhost:4001", "etcd address location")
+	env.Etcd = flag.String("etcd", "http://localhost:4001", "etcd address location (comma separated for several machines)")
 	env.EtcdDir = flag.String("etcd-dir", "/rails_app01", "etcd directory that contains the configurations")
+	var watches []string
+	flag.Var((*src.ListFlag)(&watches), "watch", "Watch an etcd directory into its own file, as <etcd dir>:<file>, or <etcd dir>:<file>:<renderer> to override -renderer (repeatable, overrides -etcd-dir)")
+	etcdCaPtr := flag.String("etcd-ca", "", "CA certificate used to verify the etcd machines")
+	etcdCertPtr := flag.String("etcd-cert", "", "Client certificate for TLS connections to etcd, loaded again when it changes")
+	etcdKeyPtr := flag.String("etcd-key", "", "Client certificate key for TLS connections to etcd")
+	etcdUserPtr := flag.String("etcd-user", "", "User to authenticate with etcd")
+	etcdPasswordPtr := flag.String("etcd-password", "", "Password to authenticate with etcd (defaults to $ETCD_PASSWORD)")
+	etcdPasswordFilePtr := flag.String("etcd-password-file", "", "File holding the password to authenticate with etcd, read again when it changes")
+	etcdApiPtr := flag.String("etcd-api", "v2", "The etcd API to use: v2 or v3")
+	backendPtr := flag.String("backend", "etcd", "Where the configuration is stored: etcd, consul or redis")
+	recursivePtr := flag.Bool("recursive", true, "Whether -etcd-dir is a directory, with -recursive=false it's a single key rendered as a one key map (etcd v2 only)")
+	consulAddrPtr := flag.String("consul-addr", "http://127.0.0.1:8500", "Address of the Consul agent, with -backend consul")
+	consulPrefixPtr := flag.String("consul-prefix", "", "Consul key prefix that contains the configurations (defaults to -etcd-dir)")
+	redisAddrPtr := flag.String("redis-addr", "127.0.0.1:6379", "Address of the Redis server, with -backend redis")
+	redisPrefixPtr := flag.String("redis-prefix", "", "Redis key prefix that contains the configurations (defaults to -etcd-dir)")
 
 	rendererPtr := flag.String("renderer", "yaml", "The renderer to use when outputing the configs")
+	flag.BoolVar(&env.StrictDir, "strict-dir", false, "Ignore (and log) keys from etcd that aren't under -etcd-dir, instead of using them whole")
+	flag.BoolVar(&env.DecodeKeys, "decode-keys", false, "URL-decode each segment of the etcd keys, so a key named a%2Fb is the single key a/b instead of b nested under a")
+	flag.IntVar(&env.StripLevels, "strip-levels", 0, "Drop this many leading directories of every key under -etcd-dir, merging what's under them at the top of the file")
+	flag.StringVar(&env.KeyTransform, "key-transform", "none", "Rename the etcd key segments before rendering: none, underscore, camelize or dasherize")
+	flag.StringVar(&env.RailsEnv, "env", "", "Only fill in this top-level section of the rendered yaml, json or toml file (e.g. production), keeping the other sections")
+	flag.BoolVar(&env.WrapEnv, "wrap-env", false, "Render the whole data under a top-level -env key with any renderer, replacing the other sections")
+	flag.StringVar(&env.Output, "output", "", "Where to write the rendered configuration, - for stdout and syslog://facility for syslog, several comma separated (defaults to the renderer's own file flag)")
+	fileModePtr := flag.String("file-mode", "", "Octal mode of the rendered file, like 0600 (defaults to keeping the mode of the file it replaces)")
+	flag.StringVar(&env.FileOwner, "file-owner", "", "User (name or id) owning the rendered file")
+	flag.StringVar(&env.FileGroup, "file-group", "", "Group (name or id) owning the rendered file")
+	flag.BoolVar(&env.Backup, "backup", false, "Copy the previous file to <file>.bak before replacing it")
+	flag.IntVar(&env.BackupKeep, "backup-keep", 0, "With -backup, keep this many timestamped backups instead of a single .bak file")
 	reloaderPtr := flag.String("reloader", "touch", "The strategy to reload the Rails app")
+	flag.BoolVar(&env.ForceReload, "force-reload", false, "Reload the Rails app even when the rendered configuration didn't change")
+	flag.BoolVar(&env.NoReload, "no-reload", false, "Never reload the Rails app, only render the configuration")
+	flag.BoolVar(&env.QuietInitial, "quiet-initial", false, "Render the configuration on startup without reloading the Rails app, only reload on later changes")
+	flag.BoolVar(&env.DryRun, "dry-run", false, "Print the rendered configuration instead of writing it, and never reload the Rails app")
+	flag.BoolVar(&env.InterpolateEnv, "interpolate-env", false, "Expand ${VAR} and $VAR in etcd values from the environment when rendering ($$ is a literal $)")
+	flag.BoolVar(&env.InterpolateStrict, "interpolate-strict", false, "Fail the render when a value references an unset variable, instead of expanding it empty")
+	flag.IntVar(&env.Indent, "indent", 2, "Spaces per indentation level of the yaml and json renderers")
+	flag.BoolVar(&env.Header, "header", true, "Start the rendered yaml, toml, ini, dotenv, properties, ruby and hcl files with a \"Generated by rails-configd\" comment")
+	flag.BoolVar(&env.ShowDiff, "show-diff", false, "Log a diff of the rendered configuration every time it changes (secrets are masked)")
+	flag.StringVar(&env.ValidateCommand, "validate-command", "", "Shell command checking the rendered file (in $RAILS_CONFIGD_FILE) before reloading, restoring the previous file if it fails")
+	flag.DurationVar(&env.ValidateTimeout, "validate-timeout", time.Minute, "How long the validate command may run (0 for no limit)")
+	flag.StringVar(&env.HealthcheckUrl, "reload-healthcheck-url", "", "After reloading, wait for this URL to answer 2xx before calling the reload a success")
+	flag.DurationVar(&env.HealthcheckTimeout, "reload-healthcheck-timeout", 30*time.Second, "How long the app may take to answer -reload-healthcheck-url after a reload")
+	flag.BoolVar(&env.RollbackOnUnhealthy, "rollback-on-unhealthy", false, "Put the previous file back and reload again when the app doesn't come back healthy")
+	flag.DurationVar(&env.RenderTimeout, "render-timeout", 0, "Give up on a render taking longer than this (e.g. 30s), keeping the previous file and not reloading; no limit when 0")
+	flag.DurationVar(&env.ReloadTimeout, "reload-timeout", time.Minute, "How long the reload command, or a docker or supervisor restart, may take (0 for no limit)")
+	flag.DurationVar(&env.DrainTimeout, "drain-timeout", 10*time.Second, "How long a timed out reload command gets to exit after SIGTERM, before SIGKILL")
+	flag.IntVar(&env.ReloadRetries, "reload-retries", 3, "How many times to retry a failed reload")
+	flag.DurationVar(&env.ReloadBackoff, "reload-backoff", time.Second, "Delay before retrying a failed reload, doubled after each retry")
+	oncePtr := flag.Bool("once", false, "Render the configuration once and exit, without watching etcd")
+	dumpPtr := flag.Bool("dump", false, "Print the data read from etcd as JSON and exit, without rendering or reloading")
+	lockFilePtr := flag.String("lock-file", "", "Lock this file while running, refusing to start if another rails-configd holds it")
+	shutdownTimeoutPtr := flag.Duration("shutdown-timeout", 20*time.Second, "On SIGINT or SIGTERM, how long to wait for a running render and reload to finish")
+	debouncePtr := flag.Duration("debounce", 0, "Wait for etcd to be quiet for this long before rendering and reloading (e.g. 2s)")
+	debounceMaxPtr := flag.Duration("debounce-max", 0, "Render and reload at most this long after a change, even if changes keep coming")
+	reloadDebouncePerFilePtr := flag.Bool("reload-debounce-per-file", false, "With several -watch, reload as soon as any file is rendered after its own -debounce, instead of once all of them are quiet")
+	vaultAddrPtr := flag.String("vault-addr", "", "Resolve vault:<path>#<field> values from this Vault server (e.g. https://vault:8200)")
+	vaultTokenPtr := flag.String("vault-token", "", "The Vault token (defaults to $VAULT_TOKEN)")
+	vaultTtlPtr := flag.Duration("vault-ttl", 5*time.Minute, "How long secrets read from Vault are cached")
+	auditFilePtr := flag.String("audit-file", "", "Append a JSON line for every change from etcd to this file, reopened on SIGHUP")
+	notifySlackUrlPtr := flag.String("notify-slack-url", "", "Post the changed keys to this Slack incoming webhook whenever the file changes")
+	reloadCooldownPtr := flag.Duration("reload-cooldown", 0, "Reload at most once in this long, the file is still rendered meanwhile (e.g. 30s)")
+	reloadStaggerPtr := flag.Duration("reload-stagger", 0, "Wait a random delay up to this long before each reload, so instances watching the same directory don't all reload at once (e.g. 20s)")
+	eventBufferPtr := flag.Int("event-buffer", 100, "How many etcd events can wait while rendering and reloading, they're then applied at once")
+	maxReconnectAttemptsPtr := flag.Int("max-reconnect-attempts", 0, "Exit with an error after this many failed attempts in a row to reconnect to etcd, so the orchestrator restarts it (0 to retry forever)")
+	syncRetriesPtr := flag.Int("sync-retries", 3, "How many times to retry reading etcd on startup, before starting from the cache (or failing)")
+	startupTimeoutPtr := flag.Duration("startup-timeout", 0, "Give up reading etcd on startup after this long, retries included (e.g. 30s), then start from the cache or fail; no limit when 0")
+	resyncIntervalPtr := flag.Duration("resync-interval", 0, "Rebuild the data from a full read of etcd this often, in case it drifted (e.g. 1h), disabled when 0")
+	logFormatPtr := flag.String("log-format", "text", "How to write logs: text or json")
+	logLevelPtr := flag.String("log-level", "info", "Only log messages at this level or above: debug, info, warn or error (changes are logged at debug)")
+	httpAddrPtr := flag.String("http-addr", "", "Address for the health check and metrics HTTP server (e.g. :8080), disabled when empty")
+	flag.BoolVar(&env.CoerceTypes, "coerce-types", false, "Store values that look like numbers or booleans as such, instead of strings")
+	flag.Var((*src.ListFlag)(&env.ReloadTriggerKeys), "reload-trigger-keys", "Comma separated glob patterns of the keys whose changes reload the app, other changes only render the file (e.g. database/*)")
+	flag.Var((*src.ListFlag)(&env.StringKeys), "string-keys", "Comma separated glob patterns of keys never coerced by -coerce-types (e.g. address/zip)")
+	flag.Var((*src.ListFlag)(&env.SecretKeys), "secret-keys", "Comma separated globs or substrings of keys whose values are masked in logs and diffs (default "+strings.Join(src.DefaultSecretKeys, ",")+")")
+	flag.Var((*src.ListFlag)(&env.Include), "include", "Comma separated glob patterns of the keys (or directories) to render, all of them by default")
+	flag.Var((*src.ListFlag)(&env.Exclude), "exclude", "Comma separated glob patterns of the keys (or directories) never rendered, even if included")
+	flag.IntVar(&env.MaxValueSize, "max-value-size", 0, "Reject (and log) etcd values longer than this many bytes, keeping the previous value (0 for no limit)")
+	flag.StringVar(&env.FlattenSeparator, "flatten-separator", "", "Join nested keys with this in the dotenv, properties and ini files (defaults to __ for dotenv and . for the others)")
+	flag.BoolVar(&env.EmptyAsDelete, "empty-as-delete", false, "Treat etcd keys set to the empty string as deleted, instead of rendering an empty value")
+	flag.BoolVar(&env.ExpandJson, "expand-json", false, "Store values holding JSON objects or arrays as nested data")
+	baseConfigPtr := flag.String("base-config", "", "YAML file with defaults the etcd data is merged over")
+	flag.StringVar(&env.CacheFile, "cache-file", "tmp/rails-configd.cache", "Where to cache the etcd data, to start when etcd is unreachable")
+	noCachePtr := flag.Bool("no-cache", false, "Don't cache the etcd data, and fail to start when etcd stays unreachable")
+
+	versionPtr := flag.Bool("version", false, "Print the version and exit")
+	configPtr := flag.String("config", "", "YAML or TOML file setting any of these options, named after the flags (the command line wins)")
 
 	src.RegisterRendererFlags()
 	src.RegisterReloaderFlags()
 
 	flag.Usage = usage
 	flag.Parse()
+	if *versionPtr {
+		fmt.Printf("rails-configd %s (commit %s, built %s)\n", releaseVersion, gitCommit, buildDate)
+		return
+	}
+	if err := src.LoadEnvironment(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+	if *configPtr != "" {
+		if err := src.LoadConfigFile(*configPtr, flag.CommandLine); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := src.CheckKeyTransform(env.KeyTransform); err != nil {
+		log.Fatal(err)
+	}
+	if env.RollbackOnUnhealthy && env.HealthcheckUrl == "" {
+		log.Fatal("-rollback-on-unhealthy needs -reload-healthcheck-url")
+	}
+	if env.RollbackOnUnhealthy && (len(watches) > 0 || *reloadCooldownPtr > 0 || *reloadStaggerPtr > 0) {
+		log.Fatal("-rollback-on-unhealthy doesn't work with -watch, -reload-cooldown or -reload-stagger")
+	}
+	if env.WrapEnv && env.RailsEnv == "" {
+		log.Fatal("-wrap-env needs -env, the key to wrap the data under")
+	}
+	if *fileModePtr != "" {
+		mode, err := strconv.ParseUint(*fileModePtr, 8, 32)
+		if err != nil || mode > 0777 {
+			log.Fatalf("invalid -file-mode %q, should be octal like 0600", *fileModePtr)
+		}
+		env.FileMode = os.FileMode(mode)
+	}
+	if *baseConfigPtr != "" {
+		if err := env.LoadBase(*baseConfigPtr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := src.ValidateOutput(env.Output); err != nil {
+		log.Fatal(err)
+	}
+	if *noCachePtr {
+		env.CacheFile = ""
+	}
+	if env.SecretKeys == nil {
+		env.SecretKeys = src.DefaultSecretKeys
+	}
+
+	if err := src.SetLogFormat(*logFormatPtr); err != nil {
+		log.Fatal(err)
+	}
+	logLevel, err := src.ParseLevel(*logLevelPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	env.Logger = &src.Logger{Level: logLevel}
+
+	// lock
+	if *lockFilePtr != "" {
+		lock, err := src.AcquireLock(*lockFilePtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer lock.Release()
+	}
 
 	// renderer
 	renderer, err := src.OpenRenderer(*rendererPtr)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
 	env.Renderer = renderer
 
 	// reloader
 	env.Reloader, err = src.OpenReloader(*reloaderPtr)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
 
-	// etcd
-	receiverChannel := make(chan *etcd.Response)
+	// vault
+	if *vaultAddrPtr != "" {
+		token := *vaultTokenPtr
+		if token == "" {
+			token = os.Getenv("VAULT_TOKEN")
+		}
+		env.Vault = src.NewVaultResolver(*vaultAddrPtr, token, *vaultTtlPtr)
+	}
+
+	// audit
+	if *auditFilePtr != "" {
+		audit, err := src.OpenAuditLog(*auditFilePtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer audit.Close()
+		env.Audit = audit
+
+		hangup := make(chan os.Signal, 1)
+		signal.Notify(hangup, syscall.SIGHUP)
+		go func() {
+			for range hangup {
+				if err := audit.Reopen(); err != nil {
+					env.Logger.Errorf("[MAIN] Cannot reopen %s: %s", *auditFilePtr, err)
+				} else {
+					env.Logger.Infof("[MAIN] Reopened %s", *auditFilePtr)
+				}
+			}
+		}()
+	}
+
+	// notifications
+	if *notifySlackUrlPtr != "" {
+		env.Notifier = src.NewSlackNotifier(*notifySlackUrlPtr)
+	}
+
+	// health checks
+	if *httpAddrPtr != "" {
+		if err := src.StartHTTP(*httpAddrPtr, env.Status); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// backend
 	stopChannel := make(chan bool)
-	etcdClient := etcd.NewClient([]string{*env.Etcd})
-	success := etcdClient.SyncCluster()
-	if !success {
-		log.Fatal("Cannot sync with etcd machines, please check --etcd")
+	var backend src.Backend
+	switch *backendPtr {
+	case "etcd":
+		machines, err := src.ParseEtcdEndpoints(*env.Etcd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		env.Logger.Log(src.LevelInfo, src.Fields{"machines": machines}, "[MAIN] Using etcd machines %s", strings.Join(machines, ", "))
+
+		if *etcdPasswordPtr != "" && *etcdPasswordFilePtr != "" {
+			log.Fatal("pass either -etcd-password or -etcd-password-file")
+		}
+		connect := func() (src.Backend, error) {
+			password := *etcdPasswordPtr
+			if *etcdPasswordFilePtr != "" {
+				var err error
+				if password, err = src.ReadPasswordFile(*etcdPasswordFilePtr); err != nil {
+					return nil, err
+				}
+			}
+			if password == "" {
+				password = os.Getenv("ETCD_PASSWORD")
+			}
+
+			switch *etcdApiPtr {
+			case "v2":
+				etcdClient, err := src.NewEtcdClient(machines, *etcdCaPtr, *etcdCertPtr, *etcdKeyPtr)
+				if err != nil {
+					return nil, err
+				}
+				if *etcdUserPtr != "" {
+					etcdClient.SetCredentials(*etcdUserPtr, password)
+				}
+				return etcdClient, nil
+			case "v3":
+				if *etcdUserPtr == "" {
+					password = ""
+				}
+				return src.NewEtcdV3Client(machines, *etcdCaPtr, *etcdCertPtr, *etcdKeyPtr, *etcdUserPtr, password)
+			default:
+				return nil, fmt.Errorf("unknown etcd API %q, should be v2 or v3", *etcdApiPtr)
+			}
+		}
+		backend, err = connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// rebuilt when the mounted credentials rotate
+		var credentials []string
+		for _, file := range []string{*etcdCaPtr, *etcdCertPtr, *etcdKeyPtr, *etcdPasswordFilePtr} {
+			if file != "" {
+				credentials = append(credentials, file)
+			}
+		}
+		if len(credentials) > 0 {
+			rotating := src.NewRotatingBackend(backend, connect, credentials, env.Logger)
+			go rotating.Poll(stopChannel)
+			backend = rotating
+		}
+	case "consul":
+		env.Logger.Log(src.LevelInfo, src.Fields{"consul": *consulAddrPtr}, "[MAIN] Using consul agent %s", *consulAddrPtr)
+		backend = src.NewConsulClient(*consulAddrPtr)
+		if *consulPrefixPtr != "" {
+			env.EtcdDir = consulPrefixPtr
+		}
+	case "redis":
+		env.Logger.Log(src.LevelInfo, src.Fields{"redis": *redisAddrPtr}, "[MAIN] Using redis server %s", *redisAddrPtr)
+		backend = src.NewRedisClient(*redisAddrPtr)
+		if *redisPrefixPtr != "" {
+			env.EtcdDir = redisPrefixPtr
+		}
+	default:
+		log.Fatalf("unknown backend %q, should be etcd, consul or redis", *backendPtr)
 	}
 
-	etcdResponse, err := etcdClient.Get(*env.EtcdDir, false, true)
-	if err != nil {
-		panic(err)
+	if !*recursivePtr && (*backendPtr != "etcd" || *etcdApiPtr != "v2") {
+		log.Fatal("-recursive=false only works with the etcd v2 API")
+	}
+	if !*recursivePtr && env.StripLevels > 0 {
+		log.Fatal("-strip-levels doesn't work with -recursive=false")
+	}
+	if env.StripLevels < 0 {
+		log.Fatal("-strip-levels can't be negative")
+	}
+
+	// watches
+	envs := []*src.Env{&env}
+	var reloadQueue *src.ReloadQueue
+	if len(watches) > 0 || *reloadCooldownPtr > 0 || *reloadStaggerPtr > 0 {
+		reloadQueue = src.NewReloadQueue(&env)
+		reloadQueue.Cooldown = *reloadCooldownPtr
+		reloadQueue.Stagger = *reloadStaggerPtr
+		env.ReloadQueue = reloadQueue
+	}
+	if len(watches) > 0 {
+		if !*reloadDebouncePerFilePtr {
+			reloadQueue.Debounce = *debouncePtr
+		}
+		envs = nil
+
+		for _, watch := range watches {
+			dir, output, rendererName, err := src.ParseWatch(watch)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			watchEnv := env
+			watchEnv.EtcdDir = &dir
+			watchEnv.Output = output
+			if rendererName != "" {
+				watchEnv.Renderer, err = src.OpenRenderer(rendererName)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			watchEnv.Data = make(map[string]interface{})
+			watchEnv.ReloadQueue = reloadQueue
+			if env.CacheFile != "" {
+				watchEnv.CacheFile = env.CacheFile + "." + strings.Replace(strings.Trim(dir, "/"), "/", "_", -1)
+			}
+			envs = append(envs, &watchEnv)
+		}
+	}
+
+	watchers := make([]*src.Watcher, len(envs))
+	for i, watchEnv := range envs {
+		watcher := src.NewWatcher(backend, watchEnv)
+		watcher.Debounce = *debouncePtr
+		watcher.DebounceMax = *debounceMaxPtr
+		watcher.ResyncInterval = *resyncIntervalPtr
+		watcher.EventBuffer = *eventBufferPtr
+		watcher.Recursive = *recursivePtr
+		watcher.MaxReconnectAttempts = *maxReconnectAttemptsPtr
+		watcher.Rerender = make(chan bool, 1)
+		if err := watcher.SyncRetrying(*syncRetriesPtr, *startupTimeoutPtr); err != nil {
+			if watchEnv.CacheFile == "" {
+				log.Fatal(err)
+			}
+			if cacheErr := watchEnv.LoadCache(); cacheErr != nil {
+				log.Fatalf("%s, and cannot load the cache: %s", err, cacheErr)
+			}
+			env.Logger.Warnf("[MAIN] %s, starting from the cached configuration in %s", err, watchEnv.CacheFile)
+		}
+		if *dumpPtr {
+			continue
+		}
+		if err := watchEnv.Cycle(); err != nil {
+			log.Fatal(err)
+		}
+		watchers[i] = watcher
 	}
-	if !etcdResponse.Node.Dir {
-		panic("etc-dir should be a directory")
+	if *dumpPtr {
+		dumped := envs[0]
+		if len(watches) > 0 {
+			// one section per watched directory
+			dumped = &src.Env{Indent: env.Indent, Data: make(map[string]interface{})}
+			for _, watchEnv := range envs {
+				dumped.Data[*watchEnv.EtcdDir] = watchEnv.Data
+			}
+		}
+		if err := dumped.Dump(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	env.BuildData(*etcdResponse.Node, *env.EtcdDir, env.Data)
-	env.Cycle()
+	if reloadQueue != nil {
+		if err := reloadQueue.Flush(); err != nil {
+			log.Fatalf("reload failed: %s", err)
+		}
+	}
+	env.Status.SetReady()
 
-	log.Printf("[MAIN] Waiting for changes from etcd @ %s", *env.EtcdDir)
-	go etcdClient.Watch(*env.EtcdDir, 0, true, receiverChannel, stopChannel)
+	if *oncePtr {
+		return
+	}
+
+	for _, watchEnv := range envs {
+		env.Logger.Log(src.LevelInfo, src.Fields{"etcd_dir": *watchEnv.EtcdDir}, "[MAIN] Waiting for changes from etcd @ %s", *watchEnv.EtcdDir)
+	}
+
+	// systemd
+	if _, err := src.SdNotify("READY=1"); err != nil {
+		env.Logger.Warnf("[MAIN] Cannot notify systemd: %s", err)
+	}
+	if interval := src.SdWatchdogInterval(); interval > 0 {
+		// the watchdog is pinged only while every watcher keeps beating
+		heartbeats := make([]*src.Heartbeat, len(watchers))
+		for i, watcher := range watchers {
+			heartbeats[i] = src.NewHeartbeat()
+			watcher.Heartbeat = heartbeats[i]
+			watcher.HeartbeatInterval = interval / 4
+		}
+		go src.SdWatchdog(interval, heartbeats, env.Logger, stopChannel)
+	}
 
 	// signals
-	osSignal := make(chan os.Signal)
-	signal.Notify(osSignal, os.Interrupt)
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for received := range usr1 {
+			env.Logger.Infof("[MAIN] %s received, re-rendering from etcd", received)
+			for _, watcher := range watchers {
+				select {
+				case watcher.Rerender <- true:
+				default:
+					// one is already waiting
+				}
+			}
+		}
+	}()
+
+	osSignal := make(chan os.Signal, 1)
+	signal.Notify(osSignal, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		for _ = range osSignal {
-			log.Print("Interrupt received, finishing")
-			stopChannel <- true
+		received := <-osSignal
+		env.Logger.Infof("[MAIN] %s received, shutting down, waiting for render to finish", received)
+		src.SdNotify("STOPPING=1")
+		close(stopChannel)
+
+		select {
+		case received = <-osSignal:
+			env.Logger.Warnf("[MAIN] %s received again, exiting now", received)
+		case <-time.After(*shutdownTimeoutPtr):
+			env.Logger.Errorf("[MAIN] Render still running after %s, exiting now", *shutdownTimeoutPtr)
 		}
+		os.Exit(1)
 	}()
 
-	loop(receiverChannel, env)
+	var running sync.WaitGroup
+	if reloadQueue != nil {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			reloadQueue.Run(stopChannel)
+		}()
+	}
+	for _, watcher := range watchers {
+		running.Add(1)
+		go func(watcher *src.Watcher) {
+			defer running.Done()
+			if err := watcher.Run(stopChannel); err != nil {
+				log.Fatal(err)
+			}
+		}(watcher)
+	}
+	running.Wait()
+	env.Logger.Infof("[MAIN] Finished")
 }
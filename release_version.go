@@ -1,3 +1,9 @@
 package main
 
 const releaseVersion = "0.1.0"
+
+// Set at build time with -ldflags "-X main.gitCommit=... -X main.buildDate=..."
+var (
+	gitCommit = "unknown"
+	buildDate = "unknown"
+)
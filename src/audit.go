@@ -0,0 +1,160 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditLog appends a JSON line to a file for every change applied to the
+// data, apart from the main log. Each line is synced to disk as it's written.
+// It's safe to use from several watchers at once.
+type AuditLog struct {
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// A line of the audit log. Values of secret keys are masked.
+type auditEntry struct {
+	Time    string `json:"time"`
+	EtcdDir string `json:"etcd_dir"`
+	Action  string `json:"action"`
+	Key     string `json:"key"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+	Index   uint64 `json:"index"`
+}
+
+// Opens path for appending, creating it if needed.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	audit := &AuditLog{path: path}
+	if err := audit.Reopen(); err != nil {
+		return nil, err
+	}
+	return audit, nil
+}
+
+// Closes the file and opens path again, so once logrotate moved it away a new
+// file is written.
+func (audit *AuditLog) Reopen() error {
+	file, err := os.OpenFile(audit.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	if audit.file != nil {
+		audit.file.Close()
+	}
+	audit.file = file
+	return nil
+}
+
+func (audit *AuditLog) Close() error {
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	return audit.file.Close()
+}
+
+func (audit *AuditLog) write(entry auditEntry) error {
+	out, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	if _, err := audit.file.Write(append(out, '\n')); err != nil {
+		return err
+	}
+	return audit.file.Sync()
+}
+
+// Writes a change to the Audit log, with the value the key held before.
+func (env *Env) audit(parts []string, action string, old interface{}, value string, index uint64) {
+	entry := auditEntry{
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+		Action: action,
+		Key:    strings.Join(parts, "/"),
+		Old:    env.auditValue(parts, old),
+		New:    env.maskValue(parts, value),
+		Index:  index,
+	}
+	if env.EtcdDir != nil {
+		entry.EtcdDir = *env.EtcdDir
+	}
+
+	if err := env.Audit.write(entry); err != nil {
+		env.Logger.Errorf("[AUDIT] Cannot write to %s: %s", env.Audit.path, err)
+	}
+}
+
+// Describes the value of the key made of parts for the audit log, masked if
+// it's a secret. Directories are written as JSON, with the secrets they hold
+// masked.
+func (env *Env) auditValue(parts []string, value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return ""
+	case map[string]interface{}, []interface{}:
+		out, _ := json.Marshal(env.maskData(parts, value))
+		return string(out)
+	default:
+		return env.maskValue(parts, fmt.Sprint(value))
+	}
+}
+
+// Copies value, the data at the key made of parts, with the values of its
+// secret keys masked.
+func (env *Env) maskData(parts []string, value interface{}) interface{} {
+	child := func(key string) []string {
+		return append(parts[:len(parts):len(parts)], key)
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(value))
+		for key, data := range value {
+			masked[key] = env.maskData(child(key), data)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(value))
+		for i, data := range value {
+			masked[i] = env.maskData(child(strconv.Itoa(i)), data)
+		}
+		return masked
+	default:
+		if env.secret(parts) {
+			return secretMask
+		}
+		return value
+	}
+}
+
+// Returns the value at the key made of parts, or nil.
+func lookupData(data map[string]interface{}, parts []string) interface{} {
+	var value interface{} = data
+	for _, part := range parts {
+		value = childValue(value, part)
+	}
+	return value
+}
+
+func childValue(value interface{}, key string) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return value[key]
+	case []interface{}:
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(value) {
+			return value[i]
+		}
+	}
+	return nil
+}
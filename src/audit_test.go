@@ -0,0 +1,61 @@
+package src
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+func readAudit(t *testing.T, path string) []auditEntry {
+	in, _ := ioutil.ReadFile(path)
+
+	var entries []auditEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(in)), "\n") {
+		var entry auditEntry
+		assert.Equal(t, json.Unmarshal([]byte(line), &entry), nil)
+		entry.Time = ""
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	audit, err := OpenAuditLog(path)
+	assert.Equal(t, err, nil)
+	defer audit.Close()
+
+	watcher := newTestWatcher(&MockEtcdClient{})
+	watcher.Env.Data = map[string]interface{}{"database": map[string]interface{}{"host": "db01", "password": "hunter2"}}
+	watcher.Env.SecretKeys = DefaultSecretKeys
+	watcher.Env.Audit = audit
+
+	watcher.apply(&etcd.Response{Action: "set", Node: &etcd.Node{Key: "/rails/database/host", Value: "db02", ModifiedIndex: 11}})
+	watcher.apply(&etcd.Response{Action: "set", Node: &etcd.Node{Key: "/rails/database/password", Value: "letmein", ModifiedIndex: 12}})
+	watcher.apply(&etcd.Response{Action: "delete", Node: &etcd.Node{Key: "/rails/database", Dir: true, ModifiedIndex: 13}})
+
+	assert.Equal(t, readAudit(t, path), []auditEntry{
+		{EtcdDir: "/rails", Action: "set", Key: "database/host", Old: "db01", New: "db02", Index: 11},
+		{EtcdDir: "/rails", Action: "set", Key: "database/password", Old: "***", New: "***", Index: 12},
+		{EtcdDir: "/rails", Action: "delete", Key: "database", Old: `{"host":"db02","password":"***"}`, Index: 13},
+	})
+
+	// once rotated, the log goes on in a new file
+	os.Rename(path, path+".1")
+	assert.Equal(t, audit.Reopen(), nil)
+	watcher.apply(&etcd.Response{Action: "set", Node: &etcd.Node{Key: "/rails/pool", Value: "5", ModifiedIndex: 14}})
+
+	assert.Equal(t, len(readAudit(t, path+".1")), 3)
+	assert.Equal(t, readAudit(t, path), []auditEntry{
+		{EtcdDir: "/rails", Action: "set", Key: "pool", New: "5", Index: 14},
+	})
+}
@@ -0,0 +1,43 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// How timestamped backups are named, sorting from oldest to newest
+const backupTimeFormat = "20060102T150405.000"
+
+// Copies the current contents of the file at path to <path>.bak, or with
+// BackupKeep to <path>.<timestamp>.bak, removing all but the newest BackupKeep
+// backups. Backups keep the mode of the file they copy.
+func (env *Env) backup(path string, current []byte) error {
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	if env.BackupKeep <= 0 {
+		return replaceFile(path+".bak", current, perm, -1, -1)
+	}
+
+	name := path + "." + time.Now().Format(backupTimeFormat) + ".bak"
+	if err := replaceFile(name, current, perm, -1, -1); err != nil {
+		return err
+	}
+
+	backups, err := filepath.Glob(path + ".*.bak")
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > env.BackupKeep {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
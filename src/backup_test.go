@@ -0,0 +1,54 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestBackup(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yml")
+	env := Env{Backup: true}
+
+	// nothing to back up for a new file
+	env.writeConfig(file, []byte("pool: 5\n"))
+	_, err := os.Stat(file + ".bak")
+	assert.Equal(t, os.IsNotExist(err), true)
+
+	os.Chmod(file, 0600)
+	env.writeConfig(file, []byte("pool: 10\n"))
+	out, _ := ioutil.ReadFile(file + ".bak")
+	assert.Equal(t, string(out), "pool: 5\n")
+	info, _ := os.Stat(file + ".bak")
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0600))
+
+	// unchanged files aren't backed up again
+	env.writeConfig(file, []byte("pool: 10\n"))
+	out, _ = ioutil.ReadFile(file + ".bak")
+	assert.Equal(t, string(out), "pool: 5\n")
+}
+
+func TestBackupKeep(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yml")
+	env := Env{Backup: true, BackupKeep: 2}
+
+	for _, pool := range []string{"1", "2", "3", "4"} {
+		env.writeConfig(file, []byte("pool: "+pool+"\n"))
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	backups, _ := filepath.Glob(file + ".*.bak")
+	assert.Equal(t, len(backups), 2)
+	out, _ := ioutil.ReadFile(backups[1])
+	assert.Equal(t, string(out), "pool: 3\n")
+}
@@ -0,0 +1,53 @@
+package src
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Loads the Base data from a YAML (or JSON) file.
+func (env *Env) LoadBase(path string) error {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var parsed interface{}
+	if err := yaml.Unmarshal(in, &parsed); err != nil {
+		return fmt.Errorf("cannot parse %s: %s", path, err)
+	}
+
+	base, ok := stringKeys(parsed).(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s should hold a map", path)
+	}
+	env.Base = base
+	return nil
+}
+
+// Turns the map[interface{}]interface{} maps the YAML parser returns into the
+// map[string]interface{} maps used by the data.
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			converted[fmt.Sprint(key)] = stringKeys(child)
+		}
+		return converted
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = stringKeys(child)
+		}
+		return value
+	case []interface{}:
+		for i, child := range value {
+			value[i] = stringKeys(child)
+		}
+		return value
+	default:
+		return value
+	}
+}
@@ -0,0 +1,73 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+func TestLoadBase(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "defaults.yml")
+	ioutil.WriteFile(path, []byte(`{"database": {"adapter": "postgresql", "host": "localhost"}}`), 0644)
+
+	env := Env{}
+	assert.Equal(t, env.LoadBase(path), nil)
+	assert.Equal(t, env.Base, map[string]interface{}{
+		"database": map[string]interface{}{"adapter": "postgresql", "host": "localhost"},
+	})
+
+	ioutil.WriteFile(path, []byte(`["a", "b"]`), 0644)
+	assert.NotEqual(t, env.LoadBase(path), nil)
+}
+
+func TestSyncMergesBase(t *testing.T) {
+	client := &MockEtcdClient{
+		Gets: []*etcd.Response{
+			dirResponse(10, &etcd.Node{Key: "/rails/database", Dir: true, Nodes: etcd.Nodes{
+				{Key: "/rails/database/host", Value: "db01"},
+			}}),
+		},
+	}
+	watcher := newTestWatcher(client)
+	watcher.Env.Base = map[string]interface{}{
+		"database": map[string]interface{}{"adapter": "postgresql", "host": "localhost"},
+		"cache":    "memory",
+	}
+
+	assert.Equal(t, watcher.Sync(), nil)
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{
+		"database": map[string]interface{}{"adapter": "postgresql", "host": "db01"},
+		"cache":    "memory",
+	})
+
+	// the base itself is left untouched
+	assert.Equal(t, watcher.Env.Base["database"].(map[string]interface{})["host"], "localhost")
+}
+
+func TestUpdateDataRevealsBase(t *testing.T) {
+	env := Env{Base: map[string]interface{}{
+		"database": map[string]interface{}{"adapter": "postgresql", "host": "localhost"},
+	}}
+	data := map[string]interface{}{
+		"database": map[string]interface{}{"adapter": "postgresql", "host": "db01", "pool": "5"},
+	}
+
+	env.UpdateData([]string{"database", "host"}, "", "delete", data)
+	env.UpdateData([]string{"database", "pool"}, "", "delete", data)
+	assert.Equal(t, data["database"], map[string]interface{}{"adapter": "postgresql", "host": "localhost"})
+
+	env.UpdateData([]string{"database", "host"}, "db02", "set", data)
+	env.UpdateData([]string{"database"}, "", "delete", data)
+	assert.Equal(t, data["database"], map[string]interface{}{"adapter": "postgresql", "host": "localhost"})
+
+	// updating the data never changes the base
+	data["database"].(map[string]interface{})["host"] = "db03"
+	assert.Equal(t, env.Base["database"].(map[string]interface{})["host"], "localhost")
+}
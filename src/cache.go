@@ -0,0 +1,71 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Saves the data to CacheFile, so a later start can render the configuration
+// even if etcd is unreachable. The file is only readable by the owner, since
+// it holds every secret stored in etcd.
+func (env *Env) saveCache() error {
+	out, err := json.Marshal(env.Data)
+	if err != nil {
+		return err
+	}
+
+	current, err := ioutil.ReadFile(env.CacheFile)
+	if err == nil && bytes.Equal(current, out) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(env.CacheFile), 0755); err != nil {
+		return err
+	}
+	return replaceFile(env.CacheFile, out, 0600, -1, -1)
+}
+
+// Replaces the data with the contents of CacheFile.
+func (env *Env) LoadCache() error {
+	in, err := ioutil.ReadFile(env.CacheFile)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(in))
+	decoder.UseNumber()
+	data := make(map[string]interface{})
+	if err := decoder.Decode(&data); err != nil {
+		return err
+	}
+
+	env.Data = cachedNumbers(data).(map[string]interface{})
+	return nil
+}
+
+// Turns the numbers of the cached data back into the int64 and float64 values
+// the type coercion stores.
+func cachedNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = cachedNumbers(child)
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = cachedNumbers(child)
+		}
+	case json.Number:
+		if !strings.ContainsAny(value.String(), ".eE") {
+			if i, err := value.Int64(); err == nil {
+				return i
+			}
+		}
+		f, _ := value.Float64()
+		return f
+	}
+	return value
+}
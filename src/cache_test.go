@@ -0,0 +1,60 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+func TestCache(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	env := Env{CacheFile: filepath.Join(dir, "tmp", "cache"), Renderer: new(MockRenderer), Reloader: new(MockReloader)}
+	env.Data = map[string]interface{}{
+		"database": map[string]interface{}{"host": "db01", "pool": int64(5), "timeout": 1.5},
+		"hosts":    []interface{}{"a", "b"},
+	}
+	assert.Equal(t, env.Cycle(), nil)
+
+	info, err := os.Stat(env.CacheFile)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0600))
+
+	cached := Env{CacheFile: env.CacheFile}
+	assert.Equal(t, cached.LoadCache(), nil)
+	assert.Equal(t, cached.Data, env.Data)
+}
+
+func TestCacheDryRun(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	env := Env{CacheFile: filepath.Join(dir, "cache"), DryRun: true, Renderer: new(MockRenderer)}
+	assert.Equal(t, env.Cycle(), nil)
+
+	_, err := os.Stat(env.CacheFile)
+	assert.Equal(t, os.IsNotExist(err), true)
+}
+
+func TestWatcherStartsFromCache(t *testing.T) {
+	client := &MockEtcdClient{
+		Gets: []*etcd.Response{
+			dirResponse(10, &etcd.Node{Key: "/rails/hostname", Value: "db02"}),
+		},
+	}
+	watcher := newTestWatcher(client)
+	watcher.Env.Data = map[string]interface{}{"hostname": "db01"}
+
+	watcher.Run(make(chan bool))
+
+	// the cached data is replaced by a full sync before watching
+	assert.Equal(t, client.GetCalls, 1)
+	assert.Equal(t, client.WatchIndexes, []uint64{11})
+	assert.Equal(t, watcher.Env.Data["hostname"], "db02")
+	assert.Equal(t, watcher.Env.Renderer.(*MockRenderer).Calls, 1)
+}
@@ -0,0 +1,101 @@
+package src
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Sets the flags that weren't given on the command line from a YAML or TOML
+// file (picked by its extension) whose keys are the flag names, like:
+//
+//   etcd-dir: /rails_app01
+//   renderer: json
+//   watch: [/rails/db:config/database.yml, /rails/secrets:config/secrets.yml]
+//
+// Lists set the flag once for each of their items.
+func LoadConfigFile(path string, flags *flag.FlagSet) error {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var options map[string]interface{}
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		_, err = toml.Decode(string(in), &options)
+	} else {
+		var parsed interface{}
+		if err = yaml.Unmarshal(in, &parsed); err == nil {
+			var ok bool
+			if options, ok = stringKeys(parsed).(map[string]interface{}); !ok && parsed != nil {
+				err = fmt.Errorf("should hold a map")
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot parse %s: %s", path, err)
+	}
+
+	given := givenFlags(flags)
+	for name, value := range options {
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown option %q", path, name)
+		}
+		if given[name] {
+			continue
+		}
+
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, value := range values {
+			if err := flags.Set(name, fmt.Sprint(value)); err != nil {
+				return fmt.Errorf("%s: invalid value %v for %s: %s", path, value, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Sets the flags that weren't given on the command line from the environment
+// variables named after them, like RAILS_CONFIGD_ETCD_DIR for -etcd-dir.
+func LoadEnvironment(flags *flag.FlagSet) error {
+	given := givenFlags(flags)
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+
+		name := EnvironmentVariable(f.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %s", value, name, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// The environment variable setting a flag.
+func EnvironmentVariable(flagName string) string {
+	return "RAILS_CONFIGD_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// The flags already set, on the command line or otherwise.
+func givenFlags(flags *flag.FlagSet) map[string]bool {
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	return given
+}
@@ -0,0 +1,75 @@
+package src
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rails-configd.yml")
+	ioutil.WriteFile(path, []byte(`{"etcd-dir": "/rails/production", "renderer": "json", "once": true,
+		"debounce": "2s", "watch": ["/rails/db:config/database.yml", "/rails/secrets:config/secrets.yml"]}`), 0644)
+
+	flags := flag.NewFlagSet("rails-configd", flag.ContinueOnError)
+	etcdDir := flags.String("etcd-dir", "/rails_app01", "")
+	renderer := flags.String("renderer", "yaml", "")
+	once := flags.Bool("once", false, "")
+	debounce := flags.Duration("debounce", 0, "")
+	var watches []string
+	flags.Var((*ListFlag)(&watches), "watch", "")
+
+	// the command line wins over the file
+	assert.Equal(t, flags.Parse([]string{"-renderer", "toml"}), nil)
+	assert.Equal(t, LoadConfigFile(path, flags), nil)
+
+	assert.Equal(t, *etcdDir, "/rails/production")
+	assert.Equal(t, *renderer, "toml")
+	assert.Equal(t, *once, true)
+	assert.Equal(t, *debounce, 2*time.Second)
+	assert.Equal(t, watches, []string{"/rails/db:config/database.yml", "/rails/secrets:config/secrets.yml"})
+
+	ioutil.WriteFile(path, []byte(`{"etcd-directory": "/rails"}`), 0644)
+	assert.NotEqual(t, LoadConfigFile(path, flags), nil)
+}
+
+func TestLoadEnvironment(t *testing.T) {
+	os.Setenv("RAILS_CONFIGD_OUTPUT", "config/database.yml")
+	os.Setenv("RAILS_CONFIGD_DRY_RUN", "true")
+	os.Setenv("RAILS_CONFIGD_STRING_KEYS", "address/zip,phone")
+	os.Setenv("RAILS_CONFIGD_RELOAD_RETRIES", "5")
+	defer os.Unsetenv("RAILS_CONFIGD_OUTPUT")
+	defer os.Unsetenv("RAILS_CONFIGD_DRY_RUN")
+	defer os.Unsetenv("RAILS_CONFIGD_STRING_KEYS")
+	defer os.Unsetenv("RAILS_CONFIGD_RELOAD_RETRIES")
+
+	env := Env{}
+	flags := flag.NewFlagSet("rails-configd", flag.ContinueOnError)
+	flags.StringVar(&env.Output, "output", "", "")
+	flags.BoolVar(&env.DryRun, "dry-run", false, "")
+	flags.Var((*ListFlag)(&env.StringKeys), "string-keys", "")
+	flags.IntVar(&env.ReloadRetries, "reload-retries", 3, "")
+	flags.BoolVar(&env.NoReload, "no-reload", false, "")
+
+	// the command line wins over the environment
+	assert.Equal(t, flags.Parse([]string{"-reload-retries", "1"}), nil)
+	assert.Equal(t, LoadEnvironment(flags), nil)
+
+	assert.Equal(t, env.Output, "config/database.yml")
+	assert.Equal(t, env.DryRun, true)
+	assert.Equal(t, env.StringKeys, []string{"address/zip", "phone"})
+	assert.Equal(t, env.ReloadRetries, 1)
+	assert.Equal(t, env.NoReload, false)
+
+	os.Setenv("RAILS_CONFIGD_NO_RELOAD", "maybe")
+	defer os.Unsetenv("RAILS_CONFIGD_NO_RELOAD")
+	assert.NotEqual(t, LoadEnvironment(flags), nil)
+}
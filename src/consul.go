@@ -0,0 +1,212 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// ConsulClient reads and watches a prefix of the Consul key/value store,
+// presenting it to the watcher as if it were an etcd directory.
+type ConsulClient struct {
+	// Address of the Consul agent, like http://127.0.0.1:8500
+	Addr string
+	// How long each blocking query waits for changes
+	Wait time.Duration
+
+	client *http.Client
+	// The pairs seen last under each prefix, to tell what changed, as every
+	// -watch shares the client
+	pairs map[string]map[string]consulPair
+	mutex sync.Mutex
+}
+
+// A key as returned by the Consul KV API. Keys ending with a slash are folders.
+type consulPair struct {
+	Key         string
+	Value       []byte
+	ModifyIndex uint64
+}
+
+func NewConsulClient(addr string) *ConsulClient {
+	return &ConsulClient{Addr: strings.TrimRight(addr, "/"), Wait: 5 * time.Minute, client: &http.Client{}}
+}
+
+// Checks that the Consul cluster has a leader.
+func (c *ConsulClient) SyncCluster() bool {
+	response, err := c.client.Get(c.Addr + "/v1/status/leader")
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode == http.StatusOK
+}
+
+// Reads every key under the key prefix, as an etcd directory.
+func (c *ConsulClient) Get(key string, sorted, recursive bool) (*etcd.Response, error) {
+	pairs, index, err := c.list(context.Background(), key, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c.remember(key, pairs)
+	return &etcd.Response{Action: "get", EtcdIndex: index, Node: consulTree(key, pairs)}, nil
+}
+
+// Sends the changes under prefix made after waitIndex to receiver, until
+// stop is closed. Consul blocking queries return all the keys every time, so
+// the changes are found by comparing them to the keys seen last.
+func (c *ConsulClient) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	defer close(receiver)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	index := waitIndex - 1
+	for {
+		pairs, newIndex, err := c.list(ctx, prefix, index)
+		if stopping(stop) {
+			return nil, etcd.ErrWatchStoppedByUser
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		changes := consulChanges(c.remember(prefix, pairs), pairs, newIndex)
+
+		for _, response := range changes {
+			select {
+			case receiver <- response:
+			case <-stop:
+				return nil, etcd.ErrWatchStoppedByUser
+			}
+		}
+
+		// as the Consul docs advise, start over if the index goes backwards
+		if newIndex < index {
+			index = 0
+		} else {
+			index = newIndex
+		}
+	}
+}
+
+// Saves the pairs seen under prefix, returning the ones seen before.
+func (c *ConsulClient) remember(prefix string, pairs map[string]consulPair) map[string]consulPair {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.pairs == nil {
+		c.pairs = make(map[string]map[string]consulPair)
+	}
+	prefix = cleanKey(prefix)
+	previous := c.pairs[prefix]
+	c.pairs[prefix] = pairs
+	return previous
+}
+
+// Lists the pairs under prefix. With an index, blocks until something changes
+// after it (or Wait passes). Also returns the Consul index of the listing.
+func (c *ConsulClient) list(ctx context.Context, prefix string, index uint64) (map[string]consulPair, uint64, error) {
+	prefix = cleanKey(prefix)
+
+	url := c.Addr + "/v1/kv/" + prefix + "?recurse"
+	if index > 0 {
+		url += fmt.Sprintf("&index=%d&wait=%ds", index, int(c.Wait.Seconds()))
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	response, err := c.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	newIndex, _ := strconv.ParseUint(response.Header.Get("X-Consul-Index"), 10, 64)
+	pairs := make(map[string]consulPair)
+
+	switch response.StatusCode {
+	case http.StatusNotFound:
+		return pairs, newIndex, nil
+	case http.StatusOK:
+	default:
+		return nil, 0, fmt.Errorf("consul answered %s", response.Status)
+	}
+
+	var list []consulPair
+	if err := json.NewDecoder(response.Body).Decode(&list); err != nil {
+		return nil, 0, err
+	}
+	for _, pair := range list {
+		// recurse matches any key starting with the prefix, like rails_app02
+		// for rails_app0
+		key := cleanKey(pair.Key)
+		if prefix == "" || key == prefix || strings.HasPrefix(key, prefix+"/") {
+			pairs[pair.Key] = pair
+		}
+	}
+	return pairs, newIndex, nil
+}
+
+// Builds the etcd directory holding the pairs under prefix.
+func consulTree(prefix string, pairs map[string]consulPair) *etcd.Node {
+	keys := make([]flatKey, 0, len(pairs))
+	for _, pair := range pairs {
+		keys = append(keys, flatKey{Key: pair.Key, Value: string(pair.Value), Index: pair.ModifyIndex, Dir: strings.HasSuffix(pair.Key, "/")})
+	}
+	return flatTree(prefix, keys)
+}
+
+// Turns the differences between two listings into etcd set and delete
+// responses, all at index.
+func consulChanges(before, after map[string]consulPair, index uint64) []*etcd.Response {
+	var changes []*etcd.Response
+
+	for _, key := range sortedPairKeys(after) {
+		pair := after[key]
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		if old, ok := before[key]; ok && bytes.Equal(old.Value, pair.Value) {
+			continue
+		}
+		changes = append(changes, &etcd.Response{Action: "set", Node: &etcd.Node{Key: "/" + cleanKey(key), Value: string(pair.Value), ModifiedIndex: index}})
+	}
+	for _, key := range sortedPairKeys(before) {
+		if _, ok := after[key]; ok {
+			continue
+		}
+		node := &etcd.Node{Key: "/" + cleanKey(key), Dir: strings.HasSuffix(key, "/"), ModifiedIndex: index}
+		changes = append(changes, &etcd.Response{Action: "delete", Node: node})
+	}
+
+	return changes
+}
+
+func sortedPairKeys(pairs map[string]consulPair) []string {
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,89 @@
+package src
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+func TestConsulClient(t *testing.T) {
+	listings := map[string][]consulPair{
+		"": {
+			{Key: "rails/", ModifyIndex: 1},
+			{Key: "rails/database/host", Value: []byte("db01"), ModifyIndex: 3},
+			{Key: "rails/database/pool", Value: []byte("5"), ModifyIndex: 4},
+			{Key: "rails_other/secret", Value: []byte("nope"), ModifyIndex: 2},
+		},
+		"5": {
+			{Key: "rails/", ModifyIndex: 1},
+			{Key: "rails/database/host", Value: []byte("db02"), ModifyIndex: 6},
+			{Key: "rails/database/timeout", Value: []byte("10"), ModifyIndex: 7},
+		},
+	}
+	indexes := map[string]string{"": "5", "5": "7"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/v1/kv/rails")
+		index := r.URL.Query().Get("index")
+		listing, ok := listings[index]
+		if !ok {
+			// nothing changes anymore, block until the watch stops
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("X-Consul-Index", indexes[index])
+		json.NewEncoder(w).Encode(listing)
+	}))
+	defer server.Close()
+
+	client := NewConsulClient(server.URL)
+	response, err := client.Get("/rails", false, true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, response.EtcdIndex, uint64(5))
+
+	env := Env{}
+	data := map[string]interface{}{}
+	env.BuildData(*response.Node, "/rails", data)
+	assert.Equal(t, data, map[string]interface{}{
+		"database": map[string]interface{}{"host": "db01", "pool": "5"},
+	})
+
+	receiver := make(chan *etcd.Response)
+	stop := make(chan bool)
+	result := make(chan error)
+	go func() {
+		_, err := client.Watch("/rails", 6, true, receiver, stop)
+		result <- err
+	}()
+
+	var changes []string
+	for i := 0; i < 3; i++ {
+		change := <-receiver
+		changes = append(changes, change.Action+" "+change.Node.Key+" "+change.Node.Value)
+		assert.Equal(t, change.Node.ModifiedIndex, uint64(7))
+	}
+	assert.Equal(t, changes, []string{
+		"set /rails/database/host db02",
+		"set /rails/database/timeout 10",
+		"delete /rails/database/pool ",
+	})
+
+	close(stop)
+	assert.Equal(t, <-result, etcd.ErrWatchStoppedByUser)
+}
+
+func TestConsulClientRemembersEachPrefix(t *testing.T) {
+	client := NewConsulClient("http://127.0.0.1:8500")
+	db := map[string]consulPair{"rails/db/host": {Key: "rails/db/host", Value: []byte("db01")}}
+	secrets := map[string]consulPair{"rails/secrets/key": {Key: "rails/secrets/key", Value: []byte("abc")}}
+
+	assert.Equal(t, len(client.remember("/rails/db", db)), 0)
+	assert.Equal(t, len(client.remember("/rails/secrets", secrets)), 0)
+
+	// the watch on /rails/db doesn't see the keys of /rails/secrets gone
+	assert.Equal(t, client.remember("/rails/db/", db), db)
+}
@@ -0,0 +1,169 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// How often RotatingBackend checks its files for changes
+var credentialsPollInterval = 10 * time.Second
+
+// Reads a password mounted as a file, without the trailing newline.
+func ReadPasswordFile(path string) (string, error) {
+	password, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read etcd password file: %s", err)
+	}
+	return strings.TrimRight(string(password), "\r\n"), nil
+}
+
+// RotatingBackend is a Backend rebuilt with connect whenever one of the files
+// it was built from (certificates, keys, a password file) changes, so
+// short-lived credentials rotate without a restart. A running watch is ended
+// on rotation, and the watcher reconnects through the new client, resuming
+// from the last index it saw. When the new files don't make a client, like a
+// certificate that doesn't match its key yet, the current client is kept.
+type RotatingBackend struct {
+	connect func() (Backend, error)
+	files   []string
+	logger  *Logger
+
+	mutex    sync.Mutex
+	backend  Backend
+	contents [][]byte
+	// closed on the next rotation
+	rotated chan bool
+}
+
+func NewRotatingBackend(backend Backend, connect func() (Backend, error), files []string, logger *Logger) *RotatingBackend {
+	rotating := &RotatingBackend{connect: connect, files: files, logger: logger, backend: backend, rotated: make(chan bool)}
+	rotating.contents, _ = rotating.read()
+	return rotating
+}
+
+func (rotating *RotatingBackend) current() (Backend, chan bool) {
+	rotating.mutex.Lock()
+	defer rotating.mutex.Unlock()
+	return rotating.backend, rotating.rotated
+}
+
+func (rotating *RotatingBackend) SyncCluster() bool {
+	backend, _ := rotating.current()
+	return backend.SyncCluster()
+}
+
+func (rotating *RotatingBackend) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	backend, _ := rotating.current()
+	return backend.Get(key, sort, recursive)
+}
+
+// Watches through the current client, until stop is closed or the client is
+// replaced, which fails the watch so it's resumed with the new one.
+func (rotating *RotatingBackend) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	backend, rotated := rotating.current()
+
+	stopInner := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		select {
+		case <-stop:
+		case <-rotated:
+		case <-done:
+			return
+		}
+		close(stopInner)
+	}()
+
+	response, err := backend.Watch(prefix, waitIndex, recursive, receiver, stopInner)
+	close(done)
+	if err == etcd.ErrWatchStoppedByUser && !stopping(stop) && stopping(rotated) {
+		return nil, fmt.Errorf("the etcd credentials were rotated")
+	}
+	return response, err
+}
+
+// Checks the files every credentialsPollInterval until stop is closed.
+func (rotating *RotatingBackend) Poll(stop chan bool) {
+	ticker := time.NewTicker(credentialsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			rotating.Rotate()
+		}
+	}
+}
+
+// Rebuilds the client if the files changed since the last time. Reports
+// whether it was replaced.
+func (rotating *RotatingBackend) Rotate() bool {
+	contents, err := rotating.read()
+	if err != nil {
+		// maybe halfway through being replaced
+		rotating.logger.Warnf("[ETCD] Cannot check the etcd credentials: %s", err)
+		return false
+	}
+
+	rotating.mutex.Lock()
+	unchanged := equalContents(contents, rotating.contents)
+	rotating.mutex.Unlock()
+	if unchanged {
+		return false
+	}
+
+	// the contents are only saved once they make a client, so a certificate
+	// whose key isn't there yet is tried again on the next check
+	backend, err := rotating.connect()
+	if err != nil {
+		rotating.logger.Errorf("[ETCD] The etcd credentials changed, but %s, keeping the current client", err)
+		return false
+	}
+
+	rotating.mutex.Lock()
+	previous := rotating.backend
+	rotating.backend = backend
+	rotating.contents = contents
+	close(rotating.rotated)
+	rotating.rotated = make(chan bool)
+	rotating.mutex.Unlock()
+
+	if closer, ok := previous.(io.Closer); ok {
+		closer.Close()
+	}
+	rotating.logger.Infof("[ETCD] The etcd credentials changed, reconnecting with them")
+	return true
+}
+
+func (rotating *RotatingBackend) read() ([][]byte, error) {
+	contents := make([][]byte, len(rotating.files))
+	for i, file := range rotating.files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		contents[i] = content
+	}
+	return contents, nil
+}
+
+func equalContents(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
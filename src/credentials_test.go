@@ -0,0 +1,104 @@
+package src
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// An etcd client whose Watch blocks until it's stopped, telling on started
+// once it's watching.
+type BlockingEtcdClient struct {
+	MockEtcdClient
+	name    string
+	started chan bool
+}
+
+func (c *BlockingEtcdClient) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	defer close(receiver)
+	if c.started != nil {
+		c.started <- true
+	}
+	<-stop
+	return nil, etcd.ErrWatchStoppedByUser
+}
+
+func TestReadPasswordFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "password")
+	ioutil.WriteFile(file, []byte("s3cret\n"), 0600)
+	password, err := ReadPasswordFile(file)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, password, "s3cret")
+
+	_, err = ReadPasswordFile(filepath.Join(dir, "missing"))
+	assert.NotEqual(t, err, nil)
+}
+
+func TestRotatingBackend(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	cert := filepath.Join(dir, "client.crt")
+	ioutil.WriteFile(cert, []byte("first"), 0600)
+
+	var mutex sync.Mutex
+	var connectErr error
+	setConnectErr := func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		connectErr = err
+	}
+	connect := func() (Backend, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if connectErr != nil {
+			return nil, connectErr
+		}
+		return &BlockingEtcdClient{name: "second"}, nil
+	}
+	first := &BlockingEtcdClient{name: "first", started: make(chan bool, 1)}
+	rotating := NewRotatingBackend(first, connect, []string{cert}, nil)
+
+	// nothing changed
+	assert.Equal(t, rotating.Rotate(), false)
+
+	// an invalid pair keeps the current client
+	ioutil.WriteFile(cert, []byte("broken"), 0600)
+	setConnectErr(errors.New("cannot load etcd client certificate"))
+	assert.Equal(t, rotating.Rotate(), false)
+	backend, _ := rotating.current()
+	assert.Equal(t, backend.(*BlockingEtcdClient).name, "first")
+
+	// and it's tried again on the next check, until the files make a client
+	assert.Equal(t, rotating.Rotate(), false)
+
+	// a running watch ends on rotation, to be resumed with the new client
+	result := make(chan error)
+	go func() {
+		_, err := rotating.Watch("/rails", 11, true, make(chan *etcd.Response), make(chan bool))
+		result <- err
+	}()
+	<-first.started
+	ioutil.WriteFile(cert, []byte("second"), 0600)
+	setConnectErr(nil)
+	assert.Equal(t, rotating.Rotate(), true)
+	assert.Equal(t, rotating.Rotate(), false)
+	assert.Equal(t, (<-result).Error(), "the etcd credentials were rotated")
+	backend, _ = rotating.current()
+	assert.Equal(t, backend.(*BlockingEtcdClient).name, "second")
+
+	// stopping the watcher still stops the watch
+	stop := make(chan bool)
+	close(stop)
+	_, err := rotating.Watch("/rails", 11, true, make(chan *etcd.Response), stop)
+	assert.Equal(t, err, etcd.ErrWatchStoppedByUser)
+}
@@ -0,0 +1,134 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Lines of context around each change
+const diffContext = 3
+
+// Diffs above this many line pairs are not computed
+const diffMaxCells = 4000000
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+	// positions of the line in the old and new text
+	a, b int
+}
+
+// Returns a unified diff between two texts, or an empty string when they are
+// equal. Each line goes through mask before being printed.
+func unifiedDiff(from, to string, before, after []byte, mask func(string) string) string {
+	a, b := splitLines(before), splitLines(after)
+	if len(a)*len(b) > diffMaxCells {
+		return fmt.Sprintf("--- %s\n+++ %s\n(diff too large to show)\n", from, to)
+	}
+
+	ops := diffLines(a, b)
+
+	var out bytes.Buffer
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// grow the hunk while the next change is close enough to share context
+		last := i
+		for j := i; j < len(ops) && j-last <= 2*diffContext; j++ {
+			if ops[j].kind != ' ' {
+				last = j
+			}
+		}
+
+		start, end := i-diffContext, last+diffContext+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", from, to)
+		}
+		writeHunk(&out, ops[start:end], mask)
+		i = end
+	}
+
+	return out.String()
+}
+
+func writeHunk(out *bytes.Buffer, ops []diffOp, mask func(string) string) {
+	aStart, bStart := ops[0].a+1, ops[0].b+1
+	aLen, bLen := 0, 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			aLen++
+		}
+		if op.kind != '-' {
+			bLen++
+		}
+	}
+	if aLen == 0 {
+		aStart--
+	}
+	if bLen == 0 {
+		bStart--
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+	for _, op := range ops {
+		fmt.Fprintf(out, "%c%s\n", op.kind, mask(op.line))
+	}
+}
+
+// Computes the edit script between a and b from their longest common
+// subsequence.
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i, j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i, j})
+			j++
+		}
+	}
+	return ops
+}
+
+func splitLines(text []byte) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")
+}
@@ -0,0 +1,35 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	before := []byte("database:\n  host: db01\n  password: hunter2\n  pool: 5\n")
+	after := []byte("database:\n  host: db02\n  password: hunter3\n  pool: 5\n")
+
+	// the previous file had the previous password
+	env := &Env{SecretKeys: DefaultSecretKeys, Data: map[string]interface{}{"database": map[string]interface{}{"password": "hunter3"}}}
+	mask := env.lineMasker(append(env.secretValues(), "hunter2"))
+
+	diff := unifiedDiff("config.yml", "config.yml", before, after, mask)
+	assert.Equal(t, diff, `--- config.yml
++++ config.yml
+@@ -1,4 +1,4 @@
+ database:
+-  host: db01
+-  password: ***
++  host: db02
++  password: ***
+   pool: 5
+`)
+
+	assert.Equal(t, unifiedDiff("a", "b", before, before, mask), "")
+}
+
+func TestUnifiedDiffNewFile(t *testing.T) {
+	diff := unifiedDiff("config.yml", "config.yml", nil, []byte("pool: 5\n"), (&Env{}).lineMasker(nil))
+	assert.Equal(t, diff, "--- config.yml\n+++ config.yml\n@@ -0,0 +1,1 @@\n+pool: 5\n")
+}
@@ -0,0 +1,98 @@
+package src
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type DockerReloader struct {
+	Container *string
+	Socket    *string
+}
+
+// Restarts the -docker-container container through the Docker API, on the
+// unix socket in -docker-socket (or a tcp:// address). A restart taking
+// longer than -reload-timeout fails the reload, like any error of the API,
+// so it's retried as -reload-retries says.
+func (reloader *DockerReloader) Reload(env Env) error {
+	client, base, err := reloader.client(env.ReloadTimeout)
+	if err != nil {
+		return err
+	}
+
+	env.Logger.Infof("[DOCKER RELOADER] Restarting container %s", *reloader.Container)
+	response, err := client.Post(base+"/containers/"+url.PathEscape(*reloader.Container)+"/restart", "", nil)
+	if err != nil {
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			return fmt.Errorf("restarting container %s timed out after %s", *reloader.Container, env.ReloadTimeout)
+		}
+		return err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+
+	if response.StatusCode/100 != 2 {
+		// the API explains what went wrong in a JSON message
+		var failure struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &failure) == nil && failure.Message != "" {
+			return fmt.Errorf("docker answered %s: %s", response.Status, failure.Message)
+		}
+		return fmt.Errorf("docker answered %s", response.Status)
+	}
+	return nil
+}
+
+// The HTTP client talking to the Docker API, giving up after timeout (unless
+// it's 0), along with its base URL.
+func (reloader *DockerReloader) client(timeout time.Duration) (*http.Client, string, error) {
+	client := &http.Client{Timeout: timeout}
+
+	socket := *reloader.Socket
+	if !strings.Contains(socket, "://") {
+		socket = "unix://" + socket
+	}
+	address, err := url.Parse(socket)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch address.Scheme {
+	case "tcp", "http":
+		return client, "http://" + address.Host, nil
+	case "unix":
+		client.Transport = &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", address.Path)
+			},
+		}
+		return client, "http://docker", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported docker socket scheme %q", address.Scheme)
+	}
+}
+
+func (reloader *DockerReloader) RegisterFlags() {
+	reloader.Container = flag.String("docker-container", "", "The name or ID of the container the docker reloader restarts")
+	reloader.Socket = flag.String("docker-socket", "/var/run/docker.sock", "The Docker API socket (a path, or tcp://host:port)")
+}
+
+func (reloader *DockerReloader) Open() error {
+	if *reloader.Container == "" {
+		return fmt.Errorf("-docker-container is required")
+	}
+	return nil
+}
+
+func init() {
+	dockerReloader := DockerReloader{}
+	RegisterReloader("docker", &dockerReloader)
+}
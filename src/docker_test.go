@@ -0,0 +1,42 @@
+package src
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestDockerReload(t *testing.T) {
+	var method, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		switch path {
+		case "/containers/app/restart":
+			w.WriteHeader(http.StatusNoContent)
+		case "/containers/slow/restart":
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such container: gone"}`))
+		}
+	}))
+	defer server.Close()
+
+	container, socket, timeout := "app", strings.Replace(server.URL, "http://", "tcp://", 1), 50*time.Millisecond
+	reloader := DockerReloader{Container: &container, Socket: &socket}
+	env := Env{ReloadTimeout: timeout}
+	assert.Equal(t, reloader.Reload(env), nil)
+	assert.Equal(t, method, "POST")
+	assert.Equal(t, path, "/containers/app/restart")
+
+	container = "gone"
+	assert.Equal(t, reloader.Reload(env).Error(), "docker answered 404 Not Found: No such container: gone")
+
+	container = "slow"
+	assert.Equal(t, reloader.Reload(env).Error(), "restarting container slow timed out after 50ms")
+}
@@ -0,0 +1,67 @@
+package src
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type DotenvRenderer struct {
+	DotenvFile *string
+}
+
+// Renders the data as KEY=VALUE lines for dotenv. Nested keys are joined with
+// a double underscore (or -flatten-separator) and uppercased, so database/pool
+// becomes DATABASE__POOL. Arrays (from directories with numeric keys) are
+// flattened using the element index as the key segment, so servers/0 becomes
+// SERVERS__0. Lines are sorted to keep the file stable between cycles.
+func (renderer *DotenvRenderer) Render(env Env) ([]byte, error) {
+	path := env.outputPath(renderer.File())
+	env.Logger.Infof("[DOTENV RENDERER] Rendering to %s", path)
+
+	vars := make(map[string]string)
+	env.flatten("DOTENV RENDERER", env.Data, "", "__", strings.ToUpper, vars)
+
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var out bytes.Buffer
+	for _, key := range keys {
+		fmt.Fprintf(&out, "%s=%s\n", key, dotenvQuote(vars[key]))
+	}
+
+	return out.Bytes(), nil
+}
+
+func (renderer *DotenvRenderer) File() string {
+	return *renderer.DotenvFile
+}
+
+func (renderer *DotenvRenderer) Commented() bool {
+	return true
+}
+
+func (renderer *DotenvRenderer) RegisterFlags() {
+	renderer.DotenvFile = flag.String("dotenv-file", ".env", "The output of the dotenv file")
+}
+
+// Double quotes values that a shell (or dotenv) would otherwise split or
+// expand. Backslashes, quotes, dollars and backticks are escaped.
+func dotenvQuote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n\"'\\$`#;&|<>(){}*?!") {
+		return value
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`", "\n", `\n`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+func init() {
+	dotenvRenderer := DotenvRenderer{}
+	RegisterRenderer("dotenv", &dotenvRenderer)
+}
@@ -0,0 +1,45 @@
+package src
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestDotenvRender(t *testing.T) {
+	file := ".env"
+	renderer := DotenvRenderer{DotenvFile: &file}
+
+	data := map[string]interface{}{
+		"database": map[string]interface{}{"pool": "5", "password": `it's "secret"`},
+		"servers":  []interface{}{"a.example.com", "b.example.com"},
+		"greeting": "hello world",
+	}
+	out, err := renderer.Render(Env{Data: data})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `DATABASE__PASSWORD="it's \"secret\""
+DATABASE__POOL=5
+GREETING="hello world"
+SERVERS__0=a.example.com
+SERVERS__1=b.example.com
+`)
+}
+
+func TestDotenvFlattenSeparator(t *testing.T) {
+	file := ".env"
+	renderer := DotenvRenderer{DotenvFile: &file}
+
+	data := map[string]interface{}{
+		"database": map[string]interface{}{"pool": "5", "max_conn": "10"},
+	}
+	out, err := renderer.Render(Env{Data: data, FlattenSeparator: "_"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), "DATABASE_MAX_CONN=10\nDATABASE_POOL=5\n")
+
+	// max_conn can't be told apart from max/conn
+	vars := make(map[string]string)
+	ambiguous := make(map[string]bool)
+	flattenData(data, "", "_", strings.ToUpper, vars, ambiguous)
+	assert.Equal(t, ambiguous, map[string]bool{"MAX_CONN": true})
+}
@@ -0,0 +1,24 @@
+package src
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// Writes the data, as read from etcd and before it's interpolated, as an
+// indented JSON document. It's the data renderers get, so -include,
+// -exclude, -coerce-types and the -base-config defaults all show.
+func (env *Env) Dump(out io.Writer) error {
+	data := env.Data
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+
+	dumped, err := json.MarshalIndent(data, "", strings.Repeat(" ", env.indent()))
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(dumped, '\n'))
+	return err
+}
@@ -0,0 +1,36 @@
+package src
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+func TestDump(t *testing.T) {
+	env := Env{CoerceTypes: true, Exclude: []string{"secret"}}
+
+	poolNode := etcd.Node{Key: "/rails/database/pool", Value: "5"}
+	hostNode := etcd.Node{Key: "/rails/database/host", Value: "db01"}
+	secretNode := etcd.Node{Key: "/rails/secret", Value: "hunter2"}
+	databaseNode := etcd.Node{Key: "/rails/database", Dir: true, Nodes: etcd.Nodes{&poolNode, &hostNode}}
+	dirNode := etcd.Node{Dir: true, Nodes: etcd.Nodes{&databaseNode, &secretNode}}
+
+	env.Data = map[string]interface{}{}
+	env.BuildData(dirNode, "/rails", env.Data)
+
+	var out bytes.Buffer
+	assert.Equal(t, env.Dump(&out), nil)
+	assert.Equal(t, out.String(), `{
+  "database": {
+    "host": "db01",
+    "pool": 5
+  }
+}
+`)
+
+	out.Reset()
+	assert.Equal(t, (&Env{}).Dump(&out), nil)
+	assert.Equal(t, out.String(), "{}\n")
+}
@@ -1,8 +1,15 @@
 package src
 
 import (
-	"log"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-etcd/etcd"
 )
@@ -13,65 +20,694 @@ type Env struct {
 	Etcd *string
 	// Directory inside etcd that contains the configuration
 	EtcdDir *string
+	// Where the renderer writes, instead of its own file flag: comma separated
+	// files, "-" for stdout and syslog://facility
+	Output string
+	// How key segments are renamed before they're stored in Data: none,
+	// underscore, camelize or dasherize
+	KeyTransform string
+	// Ignore (and log) keys that aren't under EtcdDir, instead of using them
+	// whole
+	StrictDir bool
+	// URL-decode each segment of the etcd keys, so a key named a%2Fb is the
+	// single key a/b rather than b nested under a
+	DecodeKeys bool
+	// Drop this many leading segments of every key under EtcdDir, re-rooting
+	// the data. Keys with no more segments than that are skipped.
+	StripLevels int
+	// Only fill in this top-level section of the file (like production),
+	// keeping the others
+	RailsEnv string
+	// Render the whole data under a top-level RailsEnv key instead, whatever
+	// the renderer, replacing the other sections
+	WrapEnv bool
 	// Structure that holds the configuration data in memory
 	Data map[string]interface{}
+	// Defaults the etcd data is merged over
+	Base map[string]interface{}
 	// An instance of a renderer
 	Renderer Renderer
 	// An instance of a reloader
 	Reloader Reloader
+	// Reload even when the rendered configuration didn't change
+	ForceReload bool
+	// Only render the configuration, never reload the Rails app
+	NoReload bool
+	// Never reload the Rails app after the first Cycle, which renders the
+	// configuration it just booted with
+	QuietInitial bool
+	// Print the rendered configuration instead of writing it, and never reload
+	DryRun bool
+	// Log a diff of the configuration every time it changes
+	ShowDiff bool
+	// Expand $VAR references in values from the process environment
+	InterpolateEnv bool
+	// Fail rendering on references to unset variables, instead of leaving
+	// them empty
+	InterpolateStrict bool
+	// Replaces vault: references in values by the secrets, when set
+	Vault *VaultResolver
+	// Spaces per indentation level of the YAML and JSON renderers, 2 when 0
+	Indent int
+	// Start text files with a "Generated by rails-configd" comment
+	Header bool
+	// When the data last changed, for the header
+	ChangedAt time.Time
+	// Store values that look like numbers or booleans as such
+	CoerceTypes bool
+	// Glob patterns of keys that are always stored as strings
+	StringKeys []string
+	// Globs or substrings of keys whose values never show up in the logs
+	SecretKeys []string
+	// The secret values of the last rendered data, hidden from the file they
+	// were rendered to in the next diff
+	renderedSecrets []string
+	// Glob patterns of the keys kept in the data, all of them when empty
+	Include []string
+	// Glob patterns of the keys left out of the data, even if included
+	Exclude []string
+	// Store values holding JSON objects or arrays parsed
+	ExpandJson bool
+	// Values longer than this many bytes are rejected, keeping the previous
+	// one. 0 allows any size.
+	MaxValueSize int
+	// Setting a key to the empty string deletes it instead of storing ""
+	EmptyAsDelete bool
+	// Joins nested keys in the dotenv, properties and INI files, instead of
+	// their own separator
+	FlattenSeparator string
+	// Shell command that must accept the rendered file before reloading
+	ValidateCommand string
+	// How long ValidateCommand may run
+	ValidateTimeout time.Duration
+	// URL that must answer 2xx after a reload for it to succeed
+	HealthcheckUrl string
+	// How long the app may take to answer HealthcheckUrl
+	HealthcheckTimeout time.Duration
+	// Put the previous file back, and reload again, when the app doesn't come
+	// back healthy
+	RollbackOnUnhealthy bool
+	// Give up on a renderer running longer than this, keeping the previous
+	// file, unless it's 0
+	RenderTimeout time.Duration
+	// How long a reload (a command, or a docker or supervisor restart) may
+	// take, unless it's 0
+	ReloadTimeout time.Duration
+	// How long a timed out command gets to exit after SIGTERM, before SIGKILL
+	DrainTimeout time.Duration
+	// How many times a failed reload is retried
+	ReloadRetries int
+	// Delay before retrying a failed reload, doubled after each retry
+	ReloadBackoff time.Duration
+	// Mode of the rendered file, or 0 to keep the mode of the file it replaces
+	FileMode os.FileMode
+	// User and group owning the rendered file, unchanged when empty
+	FileOwner string
+	FileGroup string
+	// Back up the previous file before replacing it
+	Backup bool
+	// Keep this many timestamped backups instead of a single .bak file
+	BackupKeep int
+	// Where the last rendered data is cached, to start without etcd. Empty
+	// disables the cache.
+	CacheFile string
+	// Glob patterns of the keys whose changes reload the Rails app. Changes
+	// to other keys only render the file. Empty means all keys.
+	ReloadTriggerKeys []string
+	// Reloads once for several Envs, when they watch different directories
+	ReloadQueue *ReloadQueue
+	// Where every change to the data is recorded, when set
+	Audit *AuditLog
+	// Tells Slack about changes to the rendered file, when set
+	Notifier *SlackNotifier
+	// State reported by the health endpoint
+	Status *Status
+	// Where to log
+	Logger *Logger
+	// While reloading, the etcd keys (relative to EtcdDir, like
+	// database/host) that changed since the last reload, sorted. Empty when
+	// they aren't known, like for the initial reload or after a resync.
+	ChangedKeys []string
+
+	// Changes applied since the last reload, for the Notifier
+	changes []string
+	// Keys changed since the last reload, for ReloadTriggerKeys and
+	// ChangedKeys
+	changedKeys [][]string
+	// Whether Cycle ran already
+	cycled bool
 }
 
 // Cycles the rails environemnt, by rendering a new configuration
 // file and reloading the Rails processes. Uses the existing renderer
-// and reloader instances.
-func (env *Env) Cycle() {
-	log.Printf("[ENV] Rendering and reloading...")
+// and reloader instances. A failed render keeps the previous file, and the file
+// is only written when it changed and, with ValidateCommand, once the command
+// accepts it. The reload is skipped when nothing changed (unless ForceReload),
+// when no ReloadTriggerKeys changed, on the first Cycle with QuietInitial, and
+// with NoReload or DryRun. With a ReloadQueue the reload is only requested;
+// without one, RollbackOnUnhealthy puts the previous file back when the app
+// doesn't come back healthy.
+func (env *Env) Cycle() error {
+	env.Logger.Debugf("[ENV] Rendering and reloading...")
+	initial := !env.cycled
+	env.cycled = true
+
+	path := env.OutputFile()
+	validating := env.ValidateCommand != "" && !env.DryRun && path != "" && path != "-"
+	rollback := env.HealthcheckUrl != "" && env.RollbackOnUnhealthy && !env.DryRun && path != "" && path != "-"
+	var previous []byte
+	existed := false
+	var err error
+	if validating || rollback {
+		previous, err = ioutil.ReadFile(path)
+		existed = err == nil
+	}
+
+	rendered := *env
+	var changed bool
+	rendered.Data, err = env.interpolated()
+	if err == nil && env.WrapEnv {
+		rendered.Data = map[string]interface{}{env.RailsEnv: rendered.Data}
+	}
+	if err == nil {
+		changed, err = rendered.render()
+	}
+	if env.ShowDiff && changed {
+		env.renderedSecrets = rendered.secretValues()
+	}
+	env.Status.SetRender(err)
+	observeRender(err)
+	if err != nil && !changed {
+		return fmt.Errorf("render failed: %s", err)
+	}
+	// the files that were written are still reloaded
+	var renderErr error
+	if err != nil {
+		env.Logger.Errorf("[ENV] Render failed: %s, reloading the files that were written", err)
+		renderErr = fmt.Errorf("render failed: %s", err)
+	}
+	if validating && changed {
+		if err := env.validateWritten(path, previous, existed); err != nil {
+			env.Status.SetValidation(err)
+			return fmt.Errorf("validation failed: %s", err)
+		}
+	}
+	triggered := env.ForceReload || env.triggered()
+	keys := joinKeys(env.changedKeys)
+	if env.CacheFile != "" && !env.DryRun {
+		if err := env.saveCache(); err != nil {
+			env.Logger.Warnf("[ENV] Cannot save the cache: %s", err)
+		}
+	}
+	if env.NoReload || env.DryRun {
+		// no reload to wait for
+		env.announceChanges(changed, renderErr)
+		return renderErr
+	}
+	if initial && env.QuietInitial {
+		env.Logger.Infof("[ENV] Rendered the initial configuration, not reloading (-quiet-initial)")
+		return renderErr
+	}
+	if !changed && !env.ForceReload {
+		env.Logger.Infof("[ENV] Configuration didn't change, skipping reload")
+		// the changes didn't make it to the file
+		env.forgetChanges()
+		return renderErr
+	}
+	if !triggered {
+		env.Logger.Infof("[ENV] No key in -reload-trigger-keys changed, skipping reload")
+		return renderErr
+	}
+	if env.ReloadQueue != nil {
+		env.ReloadQueue.Request(env, keys...)
+		env.announceChanges(changed, renderErr)
+		return renderErr
+	}
+	env.ChangedKeys = keys
+	err = env.reload()
+	env.ChangedKeys = nil
+	if _, unhealthy := err.(unhealthyError); unhealthy && rollback && changed {
+		return env.rollback(path, previous, existed, err)
+	}
+	if err != nil {
+		return fmt.Errorf("reload failed: %s", err)
+	}
+	env.announceChanges(changed, renderErr)
+
+	return renderErr
+}
+
+// Announces the changes through the Notifier once the changed file they made
+// it to was reloaded, unless part of the render failed, then forgets them.
+// Notifying never fails the Cycle.
+func (env *Env) announceChanges(changed bool, renderErr error) {
+	if changed && renderErr == nil && env.Notifier != nil && !env.DryRun {
+		env.notify(env.changes)
+	}
+	env.forgetChanges()
+}
+
+// Forgets the changes made since the last reload, once they're reloaded (or
+// don't need to be). Until then, each Cycle adds its changes to them.
+func (env *Env) forgetChanges() {
+	env.changedKeys = nil
+	env.changes = nil
+}
+
+// Puts back the file the app was healthy with, and reloads it again.
+func (env *Env) rollback(path string, previous []byte, existed bool, unhealthy error) error {
+	env.Logger.Errorf("[ENV] Rolling %s back to the previous configuration", path)
+
+	var err error
+	if restoreErr := env.restoreFile(path, previous, existed); restoreErr != nil {
+		err = fmt.Errorf("reload failed: %s, and cannot restore the previous file: %s", unhealthy, restoreErr)
+	} else if reloadErr := env.reload(); reloadErr != nil {
+		err = fmt.Errorf("reload failed: %s, and reloading the previous file failed too: %s", unhealthy, reloadErr)
+	} else {
+		err = fmt.Errorf("reload failed: %s, rolled back to the previous file", unhealthy)
+	}
+	// the configuration from etcd still didn't make it
+	env.Status.SetReload(err)
+	return err
+}
+
+// Reloads the Rails processes, retrying up to ReloadRetries times. With
+// HealthcheckUrl, the reload only succeeds once the app answers it.
+func (env *Env) reload() error {
+	backoff := env.ReloadBackoff
+
+	err := env.Reloader.Reload(*env)
+	for retry := 1; err != nil && retry <= env.ReloadRetries; retry++ {
+		env.Logger.Warnf("[ENV] Reload failed: %s, retrying in %s (%d/%d)", err, backoff, retry, env.ReloadRetries)
+		time.Sleep(backoff)
+		backoff *= 2
+
+		err = env.Reloader.Reload(*env)
+	}
+	if err == nil && env.HealthcheckUrl != "" {
+		err = env.waitHealthy()
+	}
 
-	env.Renderer.Render(*env)
-	env.Reloader.Reload()
+	env.Status.SetReload(err)
+	observeReload(err)
+	return err
+}
+
+// The file written by the renderer, or an empty string without a renderer.
+func (env *Env) OutputFile() string {
+	if env.Renderer == nil {
+		return ""
+	}
+	return env.outputPath(env.Renderer.File())
+}
+
+// Returns how long a timed out command gets to exit after SIGTERM.
+func (env *Env) drainTimeout() time.Duration {
+	if env.DrainTimeout == 0 {
+		return defaultDrainTimeout
+	}
+	return env.DrainTimeout
+}
+
+// Returns the indentation of the YAML and JSON renderers.
+func (env *Env) indent() int {
+	if env.Indent == 0 {
+		return 2
+	}
+	return env.Indent
+}
+
+// Returns the first file of Output if set ("-" when it has none), or else the
+// path the renderer would use.
+func (env *Env) outputPath(path string) string {
+	if env.Output == "" {
+		return path
+	}
+	for _, sink := range env.outputs(path) {
+		if fileSink(sink) {
+			return sink
+		}
+	}
+	return "-"
 }
 
 // Taking a etcd node and a prefix, updates the in memory data.
 // If the etcd node represents a nested directory, this function calls recursively
-// with the new prefix, trying to create a tree structure in memory.
+// with the new prefix, trying to create a tree structure in memory. Directories
+// whose keys are 0, 1, 2, ... become lists, and etcd values override the ones
+// data already holds. Keys are filtered, decoded and renamed, and values
+// checked, as the Env options (Include, StripLevels, MaxValueSize...) say.
 func (env *Env) BuildData(node etcd.Node, prefix string, data map[string]interface{}) {
+	env.buildStripped(node, prefix, env.StripLevels, data)
+}
+
+// Descends levels directories into node before building data from them,
+// skipping the keys on the way.
+func (env *Env) buildStripped(node etcd.Node, prefix string, levels int, data map[string]interface{}) {
+	if levels <= 0 {
+		env.buildData(node, prefix, nil, data)
+		return
+	}
+
 	for i := range node.Nodes {
 		node := node.Nodes[i]
+		if env.outsideDir(node.Key, prefix) {
+			env.Logger.Errorf("[ENV] Ignoring %s, it isn't under %s", node.Key, prefix)
+			continue
+		}
+		if !node.Dir {
+			env.Logger.Warnf("[ENV] Ignoring %s, it's less than -strip-levels %d deep", node.Key, env.StripLevels)
+			continue
+		}
+		env.buildStripped(*node, prefix+"/"+env.NakedKey(node.Key, prefix), levels-1, data)
+	}
+}
+
+func (env *Env) buildData(node etcd.Node, prefix string, parts []string, data map[string]interface{}) {
+	for i := range node.Nodes {
+		node := node.Nodes[i]
+		if env.outsideDir(node.Key, prefix) {
+			env.Logger.Errorf("[ENV] Ignoring %s, it isn't under %s", node.Key, prefix)
+			continue
+		}
 		key := env.NakedKey(node.Key, prefix)
+		segment := env.decodeSegment(key)
+		path := append(parts[:len(parts):len(parts)], segment)
 
 		if node.Dir {
-			data[key] = make(map[string]interface{})
-			env.BuildData(*node, prefix+"/"+key, data[key].(map[string]interface{}))
+			if matchPrefix(env.Exclude, path) {
+				continue
+			}
+			name := env.transformKey(segment)
+			child := childMap(data, name)
+			env.buildData(*node, prefix+"/"+key, path, child)
+			if len(child) == 0 && env.filtered(path) {
+				// only holds keys that aren't included
+				continue
+			}
+			data[name] = listOrMap(child)
+		} else if env.filtered(path) || (env.EmptyAsDelete && node.Value == "") {
+			continue
+		} else if env.oversized(path, node.Value) {
+			if previous := lookupData(env.Data, env.dataKey(path)); previous != nil {
+				data[env.transformKey(segment)] = copyData(previous)
+			}
 		} else {
-			data[key] = node.Value
+			data[env.transformKey(segment)] = env.value(path, node.Value)
 		}
 	}
 }
 
 // Updates the data from an etcd watch update. Takes into consideration the type of action
-// (set or delete) and navigates through the parts until if finds the correct node to update.
-func (env *Env) UpdateData(parts []string, value string, action string, data map[string]interface{}) {
-	head := parts[0]
+// (set, create, update and compareAndSwap store the value, delete, expire and
+// compareAndDelete remove it, others are ignored) and navigates through the
+// parts until if finds the correct node to update. Deleted keys get their Base
+// value back, and lists stay lists while their indexes are contiguous. Keys and
+// values are filtered and checked like BuildData does. Reports whether the data
+// changed.
+func (env *Env) UpdateData(parts []string, value string, action string, data map[string]interface{}) bool {
+	removal := removalAction(action)
+	if !removal && !storeAction(action) {
+		env.Logger.Warnf("[ENV] Ignoring unknown etcd action %q on %s", action, strings.Join(parts, "/"))
+		return false
+	}
+	if !removal && env.EmptyAsDelete && value == "" {
+		action, removal = "delete", true
+	}
+	if matchPrefix(env.Exclude, parts) || (!removal && env.filtered(parts)) {
+		return false
+	}
+	if !removal && env.oversized(parts, value) {
+		return false
+	}
+
+	return env.updateData(parts, env.value(parts, value), action, data, env.Base)
+}
+
+func (env *Env) updateData(parts []string, value interface{}, action string, data map[string]interface{}, base map[string]interface{}) bool {
+	head := env.transformKey(parts[0])
 	tail := parts[1:]
 
+	removal := removalAction(action)
+
 	if len(tail) == 0 {
-		if action == "set" {
+		old, existed := data[head]
+		if !removal {
 			data[head] = value
+			return !existed || !reflect.DeepEqual(old, value)
 		}
-		if action == "delete" {
-			delete(data, head)
+		if original, ok := base[head]; ok {
+			data[head] = copyData(original)
+			return !existed || !reflect.DeepEqual(old, original)
 		}
-	} else {
-		if _, ok := data[head]; !ok {
-			newData := make(map[string]interface{})
-			data[head] = newData
+		delete(data, head)
+		return existed
+	}
+
+	if _, ok := data[head].(map[string]interface{}); !ok && removal {
+		if _, ok := data[head].([]interface{}); !ok {
+			// nothing to remove, the key is missing or holds a value
+			return false
+		}
+	}
+
+	_, wasMap := data[head].(map[string]interface{})
+	_, wasList := data[head].([]interface{})
+	child := childMap(data, head)
+	changed := env.updateData(tail, value, action, child, childMap(base, head))
+	data[head] = listOrMap(child)
+	return changed || !(wasMap || wasList)
+}
+
+// Remembers a change to the data: its key for ReloadTriggerKeys, and a line
+// for the next notification, with the values of secret keys masked.
+func (env *Env) recordChange(parts []string, value string, action string) {
+	if env.filtered(parts) || (!removalAction(action) && !storeAction(action)) {
+		return
+	}
+	if storeAction(action) && env.tooLarge(value) {
+		return
+	}
+
+	env.changedKeys = append(env.changedKeys, parts)
+	if env.Notifier != nil {
+		change := action + " " + strings.Join(parts, "/")
+		if storeAction(action) {
+			change += " = " + env.maskValue(parts, value)
+		}
+		env.changes = append(env.changes, change)
+	}
+}
+
+// Reports whether the changes since the last reload need one: some key
+// matches ReloadTriggerKeys, or the changes aren't known (like when the data
+// was rebuilt from scratch).
+func (env *Env) triggered() bool {
+	if len(env.ReloadTriggerKeys) == 0 || len(env.changedKeys) == 0 {
+		return true
+	}
+	for _, parts := range env.changedKeys {
+		if matchPrefix(env.ReloadTriggerKeys, parts) {
+			return true
+		}
+	}
+	return false
+}
+
+// The changed keys as slash separated paths, sorted and without duplicates.
+func joinKeys(changed [][]string) []string {
+	keys := make([]string, len(changed))
+	for i, parts := range changed {
+		keys[i] = strings.Join(parts, "/")
+	}
+	return uniqueKeys(keys)
+}
+
+// Sorts keys, dropping duplicates. nil stays nil.
+func uniqueKeys(keys []string) []string {
+	if len(keys) == 0 {
+		return nil
+	}
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+
+	unique := sorted[:1]
+	for _, key := range sorted[1:] {
+		if key != unique[len(unique)-1] {
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}
+
+// Updates the data from an etcd watch update that created a directory: an
+// empty map is stored there, unless it already holds one. Reports whether
+// the data changed.
+func (env *Env) UpdateDir(parts []string, data map[string]interface{}) bool {
+	if env.filtered(parts) {
+		return false
+	}
+	key := env.dataKey(parts)
+	switch lookupData(data, key).(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	updateDir(key, data)
+	return true
+}
+
+// Renames every segment of the etcd key parts with KeyTransform, giving the
+// path of the key in Data.
+func (env *Env) dataKey(parts []string) []string {
+	if env.KeyTransform == "" || env.KeyTransform == "none" {
+		return parts
+	}
+	renamed := make([]string, len(parts))
+	for i, part := range parts {
+		renamed[i] = env.transformKey(part)
+	}
+	return renamed
+}
+
+func updateDir(parts []string, data map[string]interface{}) {
+	child := childMap(data, parts[0])
+	if len(parts) > 1 {
+		updateDir(parts[1:], child)
+	}
+	data[parts[0]] = listOrMap(child)
+}
+
+// Reports whether the etcd action removes the key.
+func removalAction(action string) bool {
+	switch action {
+	case "delete", "expire", "compareAndDelete":
+		return true
+	}
+	return false
+}
+
+// Reports whether the etcd action stores a value in the key.
+func storeAction(action string) bool {
+	switch action {
+	case "set", "create", "update", "compareAndSwap":
+		return true
+	}
+	return false
+}
+
+// Returns the map stored under key, or a new one if there's no map there. A
+// list is returned as a map indexed by position.
+func childMap(data map[string]interface{}, key string) map[string]interface{} {
+	switch child := data[key].(type) {
+	case map[string]interface{}:
+		return child
+	case []interface{}:
+		indexed := make(map[string]interface{}, len(child))
+		for i, value := range child {
+			indexed[strconv.Itoa(i)] = value
+		}
+		return indexed
+	default:
+		return make(map[string]interface{})
+	}
+}
+
+// Deep copies maps and lists, so the copy can be updated without touching the
+// original.
+func copyData(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			copied[key] = copyData(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, child := range value {
+			copied[i] = copyData(child)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// Turns a map whose keys are exactly 0 to len-1 into a list. Any other map,
+// including an empty one, is returned unchanged.
+func listOrMap(data map[string]interface{}) interface{} {
+	if len(data) == 0 {
+		return data
+	}
+
+	list := make([]interface{}, len(data))
+	for i := range list {
+		value, ok := data[strconv.Itoa(i)]
+		if !ok {
+			return data
 		}
-		env.UpdateData(tail, value, action, data[head].(map[string]interface{}))
+		list[i] = value
 	}
+	return list
 }
 
-// Removes the prefix from a key, including trailing slashes
+// Removes the prefix from a key, including trailing slashes. Both are normalized
+// first, so duplicate or trailing slashes never produce empty key segments.
 func (env *Env) NakedKey(key string, prefix string) string {
-	key = strings.Replace(key, prefix, "", -1)
-	return strings.Replace(key, "/", "", 1)
+	key = cleanKey(key)
+	prefix = cleanKey(prefix)
+
+	if prefix == "" || !strings.HasPrefix(key+"/", prefix+"/") {
+		return key
+	}
+	return strings.TrimPrefix(key[len(prefix):], "/")
+}
+
+// Reports whether StrictDir rejects the key for not being under prefix.
+func (env *Env) outsideDir(key string, prefix string) bool {
+	prefix = cleanKey(prefix)
+	return env.StrictDir && prefix != "" && !strings.HasPrefix(cleanKey(key)+"/", prefix+"/")
+}
+
+// Splits the naked key into its parts, decoded with DecodeKeys. Returns no
+// parts for the prefix itself.
+func (env *Env) KeyParts(key string, prefix string) []string {
+	key = env.NakedKey(key, prefix)
+	if key == "" {
+		return nil
+	}
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = env.decodeSegment(part)
+	}
+	return parts
+}
+
+// URL-decodes a key segment with DecodeKeys. It's only ever done once, after
+// splitting the key on its slashes, so a%252Fb becomes a%2Fb. A segment that
+// isn't validly encoded, like 100%, is kept as it is.
+func (env *Env) decodeSegment(segment string) string {
+	if !env.DecodeKeys {
+		return segment
+	}
+	decoded, err := url.PathUnescape(segment)
+	if err != nil {
+		return segment
+	}
+	return decoded
+}
+
+// Drops the first StripLevels parts of a key, or returns nil when that leaves
+// none.
+func (env *Env) stripLevels(parts []string) []string {
+	if len(parts) <= env.StripLevels {
+		return nil
+	}
+	return parts[env.StripLevels:]
+}
+
+// Drops leading, trailing and duplicate slashes from a key.
+func cleanKey(key string) string {
+	parts := strings.FieldsFunc(key, func(r rune) bool { return r == '/' })
+	return strings.Join(parts, "/")
 }
@@ -1,7 +1,14 @@
 package src
 
 import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bmizerany/assert"
 	"github.com/coreos/go-etcd/etcd"
@@ -9,20 +16,42 @@ import (
 
 type MockRenderer struct {
 	Called bool
+	Calls  int
+	// Written to stdout when empty, so it always changes
+	Path string
+	Out  []byte
+	Err  error
 }
 
-func (r *MockRenderer) Render(env Env) {
+func (r *MockRenderer) Render(env Env) ([]byte, error) {
 	r.Called = true
+	r.Calls++
+	return r.Out, r.Err
+}
+func (r *MockRenderer) File() string {
+	if r.Path == "" {
+		return "-"
+	}
+	return r.Path
 }
 func (r *MockRenderer) RegisterFlags() {
 }
 
+// A MockRenderer rendering what its file already holds.
+func unchangedRenderer() *MockRenderer {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	path := filepath.Join(dir, "config.txt")
+	ioutil.WriteFile(path, []byte("pool: 5\n"), 0644)
+	return &MockRenderer{Path: path, Out: []byte("pool: 5\n")}
+}
+
 type MockReloader struct {
 	Called bool
 }
 
-func (r *MockReloader) Reload() {
+func (r *MockReloader) Reload(env Env) error {
 	r.Called = true
+	return nil
 }
 func (r *MockReloader) RegisterFlags() {
 }
@@ -35,6 +64,199 @@ func TestCycle(t *testing.T) {
 	assert.Equal(t, env.Reloader.(*MockReloader).Called, true)
 }
 
+func TestCycleRenderError(t *testing.T) {
+	env := Env{Renderer: &MockRenderer{Err: errors.New("boom")}, Reloader: new(MockReloader)}
+
+	err := env.Cycle()
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, env.Reloader.(*MockReloader).Called, false)
+}
+
+// A renderer blocking until released.
+type HangingRenderer struct {
+	release chan bool
+}
+
+func (r *HangingRenderer) Render(env Env) ([]byte, error) {
+	<-r.release
+	return []byte("pool: 10\n"), nil
+}
+func (r *HangingRenderer) File() string {
+	return "-"
+}
+func (r *HangingRenderer) RegisterFlags() {
+}
+
+func TestCycleRenderTimeout(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.txt")
+	ioutil.WriteFile(path, []byte("pool: 5\n"), 0644)
+
+	renderer := &HangingRenderer{release: make(chan bool)}
+	defer close(renderer.release)
+	env := Env{Renderer: renderer, Reloader: new(MockReloader), Output: path, RenderTimeout: 20 * time.Millisecond, Status: new(Status)}
+	env.Data = map[string]interface{}{"pool": "10"}
+
+	err := env.Cycle()
+	assert.Equal(t, err.Error(), "render failed: the renderer took longer than the -render-timeout of 20ms")
+	assert.Equal(t, env.Reloader.(*MockReloader).Called, false)
+	out, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(out), "pool: 5\n")
+	assert.Equal(t, env.Status.Report(false).RenderTimeouts, 1)
+
+	// a renderer in time is written
+	env.Renderer = &MockRenderer{Out: []byte("pool: 10\n")}
+	assert.Equal(t, env.Cycle(), nil)
+	out, _ = ioutil.ReadFile(path)
+	assert.Equal(t, string(out), "pool: 10\n")
+	assert.Equal(t, env.Status.Report(true).LastRenderError == nil, true)
+}
+
+func TestCycleReloadsWrittenSinks(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.txt")
+	missing := filepath.Join(dir, "missing", "config.txt")
+
+	reloader := new(MockReloader)
+	env := Env{Renderer: &MockRenderer{Out: []byte("pool: 10\n")}, Reloader: reloader, Output: missing + "," + path}
+
+	// one file failing fails the cycle, but the other is reloaded anyway
+	err := env.Cycle()
+	assert.NotEqual(t, err, nil)
+	assert.T(t, strings.HasPrefix(err.Error(), "render failed: "))
+	assert.Equal(t, reloader.Called, true)
+	out, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(out), "pool: 10\n")
+}
+
+func TestCycleShowDiffMasksSecrets(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+	var logged bytes.Buffer
+	assert.Equal(t, setLogFormat("text", &logged), nil)
+	defer setLogFormat("text", os.Stderr)
+
+	file := filepath.Join(dir, "database.yml")
+	env := Env{Renderer: &YamlRenderer{YamlFile: &file}, Reloader: new(MockReloader), ShowDiff: true, SecretKeys: DefaultSecretKeys}
+	env.Data = map[string]interface{}{"secrets": map[string]interface{}{"key_base": "hunter2"}, "host": "db01"}
+	assert.Equal(t, env.Cycle(), nil)
+
+	// the previous secret is hidden from the diff too
+	env.Data = map[string]interface{}{"secrets": map[string]interface{}{"key_base": "hunter3"}, "host": "db02"}
+	assert.Equal(t, env.Cycle(), nil)
+	assert.T(t, strings.Contains(logged.String(), "+host: db02"))
+	assert.Equal(t, strings.Contains(logged.String(), "hunter"), false)
+}
+
+// A reloader failing the first Failures times
+type FlakyReloader struct {
+	Failures int
+	Calls    int
+}
+
+func (r *FlakyReloader) Reload(env Env) error {
+	r.Calls++
+	if r.Calls <= r.Failures {
+		return errors.New("not yet")
+	}
+	return nil
+}
+func (r *FlakyReloader) RegisterFlags() {
+}
+
+func TestCycleReloadRetries(t *testing.T) {
+	reloader := &FlakyReloader{Failures: 2}
+	env := Env{Renderer: new(MockRenderer), Reloader: reloader, ReloadRetries: 2, ReloadBackoff: time.Millisecond, Status: new(Status)}
+
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, reloader.Calls, 3)
+	_, err := env.Status.LastReload()
+	assert.Equal(t, err, nil)
+
+	reloader = &FlakyReloader{Failures: 5}
+	env.Reloader = reloader
+
+	assert.NotEqual(t, env.Cycle(), nil)
+	assert.Equal(t, reloader.Calls, 3)
+	_, err = env.Status.LastReload()
+	assert.NotEqual(t, err, nil)
+}
+
+func TestCycleUnchanged(t *testing.T) {
+	renderer := unchangedRenderer()
+	defer os.RemoveAll(filepath.Dir(renderer.Path))
+	env := Env{Renderer: renderer, Reloader: new(MockReloader)}
+
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, env.Reloader.(*MockReloader).Called, false)
+
+	env.ForceReload = true
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, env.Reloader.(*MockReloader).Called, true)
+}
+
+func TestCycleReloadTriggerKeys(t *testing.T) {
+	reloader := new(MockReloader)
+	env := Env{Renderer: new(MockRenderer), Reloader: reloader, ReloadTriggerKeys: []string{"database"}}
+
+	// changes to other keys only render
+	env.recordChange([]string{"features", "signup"}, "true", "set")
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, reloader.Called, false)
+
+	env.recordChange([]string{"features", "signup"}, "false", "set")
+	env.recordChange([]string{"database", "password"}, "hunter2", "set")
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, reloader.Called, true)
+	assert.Equal(t, len(env.changedKeys), 0)
+
+	// without known changes (like after a resync) the app is reloaded
+	reloader.Called = false
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, reloader.Called, true)
+
+	// a failed render keeps the changes for the next cycle
+	env.recordChange([]string{"database", "pool"}, "10", "set")
+	env.Renderer = &MockRenderer{Err: errors.New("broken template")}
+	assert.NotEqual(t, env.Cycle(), nil)
+	assert.Equal(t, len(env.changedKeys), 1)
+}
+
+func TestCycleKeepsChangedKeysUntilReloaded(t *testing.T) {
+	reloader := &KeysReloader{Failures: 1}
+	env := Env{Renderer: new(MockRenderer), Reloader: reloader, ReloadTriggerKeys: []string{"database"}}
+
+	// skipped by the trigger keys, then failing
+	env.recordChange([]string{"features", "signup"}, "true", "set")
+	assert.Equal(t, env.Cycle(), nil)
+	env.recordChange([]string{"database", "pool"}, "10", "set")
+	assert.NotEqual(t, env.Cycle(), nil)
+
+	env.recordChange([]string{"database", "host"}, "db02", "set")
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, reloader.Reloads, [][]string{
+		{"database/pool", "features/signup"},
+		{"database/host", "database/pool", "features/signup"},
+	})
+	assert.Equal(t, len(env.changedKeys), 0)
+}
+
+func TestCycleQuietInitial(t *testing.T) {
+	reloader := new(MockReloader)
+	renderer := new(MockRenderer)
+	env := Env{Renderer: renderer, Reloader: reloader, QuietInitial: true}
+
+	// the initial configuration is still rendered
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, renderer.Calls, 1)
+	assert.Equal(t, reloader.Called, false)
+
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, reloader.Called, true)
+}
+
 func TestBuildData(t *testing.T) {
 	env := Env{}
 
@@ -49,6 +271,42 @@ func TestBuildData(t *testing.T) {
 	assert.Equal(t, mongodb["hostname"], "localhost")
 }
 
+func TestBuildDataList(t *testing.T) {
+	env := Env{}
+
+	server0 := etcd.Node{Key: "/rails/servers/0", Value: "a.example.com"}
+	server1 := etcd.Node{Key: "/rails/servers/1", Value: "b.example.com"}
+	serversNode := etcd.Node{Key: "/rails/servers", Dir: true, Nodes: etcd.Nodes{&server1, &server0}}
+	shard0 := etcd.Node{Key: "/rails/shards/0", Value: "x"}
+	shard2 := etcd.Node{Key: "/rails/shards/2", Value: "z"}
+	shardsNode := etcd.Node{Key: "/rails/shards", Dir: true, Nodes: etcd.Nodes{&shard0, &shard2}}
+	dirNode := etcd.Node{Dir: true, Nodes: etcd.Nodes{&serversNode, &shardsNode}}
+
+	data := map[string]interface{}{}
+	env.BuildData(dirNode, "/rails", data)
+
+	assert.Equal(t, data["servers"], []interface{}{"a.example.com", "b.example.com"})
+	assert.Equal(t, data["shards"], map[string]interface{}{"0": "x", "2": "z"})
+}
+
+func TestUpdateDataList(t *testing.T) {
+	env := Env{}
+
+	data := map[string]interface{}{"servers": []interface{}{"a", "b"}}
+
+	env.UpdateData([]string{"servers", "2"}, "c", "set", data)
+	assert.Equal(t, data["servers"], []interface{}{"a", "b", "c"})
+
+	env.UpdateData([]string{"servers", "2"}, "", "delete", data)
+	assert.Equal(t, data["servers"], []interface{}{"a", "b"})
+
+	env.UpdateData([]string{"servers", "0"}, "", "delete", data)
+	assert.Equal(t, data["servers"], map[string]interface{}{"1": "b"})
+
+	env.UpdateData([]string{"servers", "0"}, "a", "set", data)
+	assert.Equal(t, data["servers"], []interface{}{"a", "b"})
+}
+
 func TestUpdateData(t *testing.T) {
 	env := Env{}
 
@@ -64,6 +322,201 @@ func TestUpdateData(t *testing.T) {
 	assert.Equal(t, mongodb["hostname"], nil)
 }
 
+func TestUpdateDataActions(t *testing.T) {
+	tests := []struct {
+		action string
+		data   map[string]interface{}
+	}{
+		{"set", map[string]interface{}{"host": "db02", "pool": "5"}},
+		{"create", map[string]interface{}{"host": "db02", "pool": "5"}},
+		{"update", map[string]interface{}{"host": "db02", "pool": "5"}},
+		{"compareAndSwap", map[string]interface{}{"host": "db02", "pool": "5"}},
+		{"delete", map[string]interface{}{"pool": "5"}},
+		{"expire", map[string]interface{}{"pool": "5"}},
+		{"compareAndDelete", map[string]interface{}{"pool": "5"}},
+		{"get", map[string]interface{}{"host": "db01", "pool": "5"}},
+		{"frobnicate", map[string]interface{}{"host": "db01", "pool": "5"}},
+	}
+
+	for _, test := range tests {
+		env := Env{}
+		data := map[string]interface{}{"host": "db01", "pool": "5"}
+		env.UpdateData([]string{"host"}, "db02", test.action, data)
+		assert.Equal(t, data, test.data, test.action)
+	}
+}
+
+func TestUpdateDataChanged(t *testing.T) {
+	env := Env{Base: map[string]interface{}{"pool": "5"}}
+	data := map[string]interface{}{"database": map[string]interface{}{"host": "db01"}, "pool": "5"}
+
+	// a replayed value changes nothing
+	assert.Equal(t, env.UpdateData([]string{"database", "host"}, "db01", "set", data), false)
+	assert.Equal(t, env.UpdateData([]string{"database", "host"}, "db02", "set", data), true)
+	assert.Equal(t, env.UpdateData([]string{"database", "port"}, "5432", "set", data), true)
+
+	// nor does deleting a missing key, or one back to its base value
+	assert.Equal(t, env.UpdateData([]string{"database", "replica"}, "", "delete", data), false)
+	assert.Equal(t, env.UpdateData([]string{"pool"}, "", "delete", data), false)
+	assert.Equal(t, env.UpdateData([]string{"database", "port"}, "", "delete", data), true)
+
+	assert.Equal(t, env.UpdateDir([]string{"database"}, data), false)
+	assert.Equal(t, env.UpdateDir([]string{"cache"}, data), true)
+	assert.Equal(t, data, map[string]interface{}{"database": map[string]interface{}{"host": "db02"}, "pool": "5", "cache": map[string]interface{}{}})
+}
+
+func TestEmptyAsDelete(t *testing.T) {
+	hostNode := etcd.Node{Key: "/rails/host", Value: ""}
+	poolNode := etcd.Node{Key: "/rails/pool", Value: "5"}
+	node := etcd.Node{Key: "/rails", Dir: true, Nodes: etcd.Nodes{&hostNode, &poolNode}}
+
+	// empty values are kept by default
+	env := Env{}
+	data := make(map[string]interface{})
+	env.BuildData(node, "/rails", data)
+	assert.Equal(t, data, map[string]interface{}{"host": "", "pool": "5"})
+	assert.Equal(t, env.UpdateData([]string{"pool"}, "", "set", data), true)
+	assert.Equal(t, data, map[string]interface{}{"host": "", "pool": ""})
+
+	env = Env{EmptyAsDelete: true, Base: map[string]interface{}{"pool": "1"}}
+	data = map[string]interface{}{"pool": "1"}
+	env.BuildData(node, "/rails", data)
+	assert.Equal(t, data, map[string]interface{}{"pool": "5"})
+
+	// emptying a key deletes it, bringing back its default
+	assert.Equal(t, env.UpdateData([]string{"pool"}, "", "set", data), true)
+	assert.Equal(t, data, map[string]interface{}{"pool": "1"})
+	assert.Equal(t, env.UpdateData([]string{"host"}, "db01", "set", data), true)
+	assert.Equal(t, env.UpdateData([]string{"host"}, "", "update", data), true)
+	assert.Equal(t, data, map[string]interface{}{"pool": "1"})
+}
+
+func TestMaxValueSize(t *testing.T) {
+	env := Env{MaxValueSize: 4}
+
+	hostNode := etcd.Node{Key: "/rails/host", Value: "db01"}
+	keyNode := etcd.Node{Key: "/rails/key", Value: "a huge value"}
+	node := etcd.Node{Key: "/rails", Dir: true, Nodes: etcd.Nodes{&hostNode, &keyNode}}
+
+	env.Data = make(map[string]interface{})
+	env.BuildData(node, "/rails", env.Data)
+	assert.Equal(t, env.Data, map[string]interface{}{"host": "db01"})
+
+	env.UpdateData([]string{"host"}, "db02.example.com", "set", env.Data)
+	env.UpdateData([]string{"key"}, "abc", "set", env.Data)
+	assert.Equal(t, env.Data, map[string]interface{}{"host": "db01", "key": "abc"})
+
+	// a rebuild keeps the previous values of the oversized keys
+	data := make(map[string]interface{})
+	env.BuildData(node, "/rails", data)
+	assert.Equal(t, data, map[string]interface{}{"host": "db01", "key": "abc"})
+}
+
+func TestUpdateDataPromotion(t *testing.T) {
+	env := Env{}
+
+	data := map[string]interface{}{"database": "sqlite3"}
+
+	// a value gaining children becomes a map
+	env.UpdateData([]string{"database", "adapter"}, "pg", "set", data)
+	assert.Equal(t, data["database"], map[string]interface{}{"adapter": "pg"})
+
+	// a value set over a map replaces it
+	env.UpdateData([]string{"database"}, "sqlite3", "set", data)
+	assert.Equal(t, data["database"], "sqlite3")
+
+	// deleting under a value leaves it alone
+	env.UpdateData([]string{"database", "adapter"}, "", "delete", data)
+	assert.Equal(t, data["database"], "sqlite3")
+
+	// creating a directory over a value makes it a map, but keeps a map
+	env.UpdateDir([]string{"database", "replica"}, data)
+	assert.Equal(t, data["database"], map[string]interface{}{"replica": map[string]interface{}{}})
+	env.UpdateData([]string{"database", "replica", "host"}, "db02", "set", data)
+	env.UpdateDir([]string{"database", "replica"}, data)
+	assert.Equal(t, data["database"], map[string]interface{}{"replica": map[string]interface{}{"host": "db02"}})
+}
+
+func TestBuildDataPromotion(t *testing.T) {
+	env := Env{}
+
+	// etcd wins whatever the shape of the data already there
+	adapterNode := etcd.Node{Key: "/rails/database/adapter", Value: "pg"}
+	databaseNode := etcd.Node{Key: "/rails/database", Dir: true, Nodes: etcd.Nodes{&adapterNode}}
+	cacheNode := etcd.Node{Key: "/rails/cache", Value: "memory"}
+	node := etcd.Node{Key: "/rails", Dir: true, Nodes: etcd.Nodes{&databaseNode, &cacheNode}}
+
+	data := map[string]interface{}{"database": "sqlite3", "cache": map[string]interface{}{"store": "redis"}}
+	env.BuildData(node, "/rails", data)
+	assert.Equal(t, data, map[string]interface{}{
+		"database": map[string]interface{}{"adapter": "pg"},
+		"cache":    "memory",
+	})
+}
+
+func TestBuildDataStrictDir(t *testing.T) {
+	env := Env{StrictDir: true}
+
+	hostNode := etcd.Node{Key: "/rails/host", Value: "db01"}
+	strayNode := etcd.Node{Key: "/other/host", Value: "db02"}
+	node := etcd.Node{Key: "/rails", Dir: true, Nodes: etcd.Nodes{&hostNode, &strayNode}}
+
+	data := make(map[string]interface{})
+	env.BuildData(node, "/rails", data)
+	assert.Equal(t, data, map[string]interface{}{"host": "db01"})
+}
+
+func TestKeyTransform(t *testing.T) {
+	env := Env{KeyTransform: "underscore"}
+
+	poolNode := etcd.Node{Key: "/rails/primary-db/max-pool", Value: "5"}
+	dbNode := etcd.Node{Key: "/rails/primary-db", Dir: true, Nodes: etcd.Nodes{&poolNode}}
+	abcNode := etcd.Node{Key: "/rails/a-b-c", Value: "x"}
+	node := etcd.Node{Key: "/rails", Dir: true, Nodes: etcd.Nodes{&dbNode, &abcNode}}
+
+	data := make(map[string]interface{})
+	env.BuildData(node, "/rails", data)
+	assert.Equal(t, data, map[string]interface{}{
+		"primary_db": map[string]interface{}{"max_pool": "5"},
+		"a_b_c":      "x",
+	})
+
+	env.UpdateData([]string{"primary-db", "host-name"}, "db01", "set", data)
+	assert.Equal(t, data["primary_db"], map[string]interface{}{"max_pool": "5", "host_name": "db01"})
+
+	// deleting the etcd keys removes the renamed ones
+	env.UpdateData([]string{"primary-db", "max-pool"}, "", "delete", data)
+	env.UpdateData([]string{"a-b-c"}, "", "delete", data)
+	assert.Equal(t, data, map[string]interface{}{
+		"primary_db": map[string]interface{}{"host_name": "db01"},
+	})
+}
+
+func TestUpdateDataDeleteDirectory(t *testing.T) {
+	env := Env{}
+
+	hostNode := etcd.Node{Key: "/rails/database/primary/host", Value: "db01"}
+	portNode := etcd.Node{Key: "/rails/database/primary/port", Value: "5432"}
+	primaryNode := etcd.Node{Key: "/rails/database/primary", Dir: true, Nodes: etcd.Nodes{&hostNode, &portNode}}
+	poolNode := etcd.Node{Key: "/rails/database/pool", Value: "5"}
+	databaseNode := etcd.Node{Key: "/rails/database", Dir: true, Nodes: etcd.Nodes{&primaryNode, &poolNode}}
+	dirNode := etcd.Node{Dir: true, Nodes: etcd.Nodes{&databaseNode}}
+
+	data := map[string]interface{}{}
+	env.BuildData(dirNode, "/rails", data)
+
+	env.UpdateData([]string{"database", "primary"}, "", "delete", data)
+	assert.Equal(t, data["database"], map[string]interface{}{"pool": "5"})
+
+	env.UpdateData([]string{"database", "pool"}, "", "expire", data)
+	assert.Equal(t, data["database"], map[string]interface{}{})
+
+	// deleting something that isn't there leaves no trace
+	env.UpdateData([]string{"cache", "host"}, "", "delete", data)
+	_, ok := data["cache"]
+	assert.Equal(t, ok, false)
+}
+
 func TestNakedKey(t *testing.T) {
 	env := Env{}
 
@@ -73,3 +526,94 @@ func TestNakedKey(t *testing.T) {
 	key = env.NakedKey("/rails/production/foo/bar", "/rails/production")
 	assert.Equal(t, key, "foo/bar")
 }
+
+func TestKeyParts(t *testing.T) {
+	env := Env{}
+
+	tests := []struct {
+		key, prefix string
+		parts       []string
+	}{
+		{"/rails/production/db/pool", "/rails/production", []string{"db", "pool"}},
+		{"/rails/production/db/pool", "/rails/production/", []string{"db", "pool"}},
+		{"/rails/production/db/pool", "rails/production", []string{"db", "pool"}},
+		{"/rails//production/db//pool/", "/rails/production", []string{"db", "pool"}},
+		{"/rails/production_old/db", "/rails/production", []string{"rails", "production_old", "db"}},
+		{"/pool", "/", []string{"pool"}},
+		{"/pool", "", []string{"pool"}},
+		{"/rails/production/", "/rails/production", nil},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, env.KeyParts(test.key, test.prefix), test.parts, test.key+" "+test.prefix)
+	}
+}
+
+func TestBuildDataStripLevels(t *testing.T) {
+	hostNode := etcd.Node{Key: "/rails/team/app/database/host", Value: "db01"}
+	databaseNode := etcd.Node{Key: "/rails/team/app/database", Dir: true, Nodes: etcd.Nodes{&hostNode}}
+	appNode := etcd.Node{Key: "/rails/team/app", Dir: true, Nodes: etcd.Nodes{&databaseNode}}
+	ownerNode := etcd.Node{Key: "/rails/team/owner", Value: "ops"}
+	teamNode := etcd.Node{Key: "/rails/team", Dir: true, Nodes: etcd.Nodes{&appNode, &ownerNode}}
+	dirNode := etcd.Node{Dir: true, Nodes: etcd.Nodes{&teamNode}}
+
+	host := map[string]interface{}{"host": "db01"}
+	tests := []struct {
+		levels int
+		data   map[string]interface{}
+	}{
+		{0, map[string]interface{}{"team": map[string]interface{}{"owner": "ops", "app": map[string]interface{}{"database": host}}}},
+		{1, map[string]interface{}{"owner": "ops", "app": map[string]interface{}{"database": host}}},
+		// team/owner is too shallow
+		{2, map[string]interface{}{"database": host}},
+	}
+
+	for _, test := range tests {
+		env := Env{StripLevels: test.levels}
+		data := map[string]interface{}{}
+		env.BuildData(dirNode, "/rails", data)
+		assert.Equal(t, data, test.data)
+	}
+}
+
+func TestKeyPartsDecoded(t *testing.T) {
+	tests := []struct {
+		key    string
+		decode bool
+		parts  []string
+	}{
+		{"/rails/routes/api%2Fv1", false, []string{"routes", "api%2Fv1"}},
+		{"/rails/routes/api%2Fv1", true, []string{"routes", "api/v1"}},
+		{"/rails/routes/api/v1", true, []string{"routes", "api", "v1"}},
+		{"/rails/routes/api%252Fv1", true, []string{"routes", "api%2Fv1"}},
+		{"/rails/discount/100%", true, []string{"discount", "100%"}},
+	}
+
+	for _, test := range tests {
+		env := Env{DecodeKeys: test.decode}
+		assert.Equal(t, env.KeyParts(test.key, "/rails"), test.parts, test.key)
+	}
+}
+
+func TestBuildDataDecodeKeys(t *testing.T) {
+	env := Env{DecodeKeys: true}
+
+	encodedNode := etcd.Node{Key: "/rails/routes/api%2Fv1", Value: "api-v1"}
+	plainNode := etcd.Node{Key: "/rails/routes/admin", Dir: true, Nodes: etcd.Nodes{
+		&etcd.Node{Key: "/rails/routes/admin/users", Value: "admin-users"},
+	}}
+	routesNode := etcd.Node{Key: "/rails/routes", Dir: true, Nodes: etcd.Nodes{&encodedNode, &plainNode}}
+	dirNode := etcd.Node{Dir: true, Nodes: etcd.Nodes{&routesNode}}
+
+	data := map[string]interface{}{}
+	env.BuildData(dirNode, "/rails", data)
+	assert.Equal(t, data, map[string]interface{}{
+		"routes": map[string]interface{}{
+			"api/v1": "api-v1",
+			"admin":  map[string]interface{}{"users": "admin-users"},
+		},
+	})
+
+	env.UpdateData(env.KeyParts("/rails/routes/api%2Fv2", "/rails"), "api-v2", "set", data)
+	assert.Equal(t, data["routes"].(map[string]interface{})["api/v2"], "api-v2")
+}
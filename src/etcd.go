@@ -0,0 +1,93 @@
+package src
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+const (
+	// etcd error code for "The request requires user authentication"
+	etcdErrorUnauthorized = 110
+	// etcd error code for "the event in requested index is outdated and cleared"
+	etcdErrorIndexCleared = 401
+)
+
+// Creates the etcd client. When a client certificate is given the client
+// talks TLS, otherwise it's a plain HTTP client.
+func NewEtcdClient(machines []string, caFile, certFile, keyFile string) (*etcd.Client, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return etcd.NewClient(machines), nil
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("etcd TLS needs both -etcd-cert and -etcd-key")
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return nil, fmt.Errorf("cannot load etcd client certificate %s / %s: %s", certFile, keyFile, err)
+	}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read etcd CA file: %s", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no PEM certificates found in etcd CA file %s", caFile)
+		}
+	}
+
+	return etcd.NewTLSClient(machines, certFile, keyFile, caFile)
+}
+
+// Parses a comma separated list of etcd endpoints, making sure each one is
+// an http(s) URL.
+func ParseEtcdEndpoints(endpoints string) ([]string, error) {
+	var machines []string
+
+	for _, endpoint := range strings.Split(endpoints, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" {
+			continue
+		}
+
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid etcd endpoint %q: %s", endpoint, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid etcd endpoint %q: expected http(s)://host:port", endpoint)
+		}
+
+		machines = append(machines, endpoint)
+	}
+
+	if len(machines) == 0 {
+		return nil, fmt.Errorf("no etcd endpoints given")
+	}
+
+	return machines, nil
+}
+
+// Extracts the etcd error code from err, or 0 if it isn't an etcd error.
+func etcdErrorCode(err error) int {
+	switch err := err.(type) {
+	case etcd.EtcdError:
+		return err.ErrorCode
+	case *etcd.EtcdError:
+		return err.ErrorCode
+	}
+	return 0
+}
+
+// Makes authentication errors from etcd easier to recognize in the logs.
+func describeEtcdError(err error) error {
+	if etcdErrorCode(err) == etcdErrorUnauthorized {
+		return fmt.Errorf("etcd authentication failed, please check -etcd-user and -etcd-password (%s)", err)
+	}
+	return err
+}
@@ -0,0 +1,18 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestParseEtcdEndpoints(t *testing.T) {
+	machines, err := ParseEtcdEndpoints("http://10.0.0.1:4001, http://10.0.0.2:4001,https://10.0.0.3:4001")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, machines, []string{"http://10.0.0.1:4001", "http://10.0.0.2:4001", "https://10.0.0.3:4001"})
+
+	for _, endpoints := range []string{"", "localhost:4001", "http://", "ftp://10.0.0.1"} {
+		_, err = ParseEtcdEndpoints(endpoints)
+		assert.NotEqual(t, err, nil, endpoints)
+	}
+}
@@ -0,0 +1,141 @@
+package src
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// EtcdV3Client reads and watches a prefix through the etcd v3 (gRPC) API,
+// presenting its keys to the watcher as an etcd v2 directory. Revisions stand
+// in for the v2 indexes.
+type EtcdV3Client struct {
+	client *clientv3.Client
+}
+
+// Creates the v3 client, talking TLS when a client certificate is given and
+// authenticating when user is set.
+func NewEtcdV3Client(machines []string, caFile, certFile, keyFile, user, password string) (*EtcdV3Client, error) {
+	config := clientv3.Config{Endpoints: machines, DialTimeout: 5 * time.Second, Username: user, Password: password}
+
+	if caFile != "" || certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("etcd TLS needs both -etcd-cert and -etcd-key")
+		}
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load etcd client certificate %s / %s: %s", certFile, keyFile, err)
+		}
+		config.TLS = &tls.Config{Certificates: []tls.Certificate{certificate}}
+
+		if caFile != "" {
+			ca, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read etcd CA file: %s", err)
+			}
+			config.TLS.RootCAs = x509.NewCertPool()
+			if !config.TLS.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("no PEM certificates found in etcd CA file %s", caFile)
+			}
+		}
+	}
+
+	client, err := clientv3.New(config)
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdV3Client{client: client}, nil
+}
+
+// Refreshes the list of cluster members.
+func (c *EtcdV3Client) SyncCluster() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.client.Sync(ctx) == nil
+}
+
+// Closes the connections to the cluster.
+func (c *EtcdV3Client) Close() error {
+	return c.client.Close()
+}
+
+// Reads every key under the key prefix with a single Range, as an etcd
+// directory.
+func (c *EtcdV3Client) Get(key string, sorted, recursive bool) (*etcd.Response, error) {
+	response, err := c.client.Get(context.Background(), v3Prefix(key), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]flatKey, len(response.Kvs))
+	for i, kv := range response.Kvs {
+		keys[i] = flatKey{Key: string(kv.Key), Value: string(kv.Value), Index: uint64(kv.ModRevision)}
+	}
+	return &etcd.Response{Action: "get", EtcdIndex: uint64(response.Header.Revision), Node: flatTree(key, keys)}, nil
+}
+
+// Sends the changes under prefix from revision waitIndex on to receiver, as
+// v2 "set" and "delete" responses, until stop is closed. A compacted revision
+// fails like a cleared v2 index, so the watcher resyncs.
+func (c *EtcdV3Client) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	defer close(receiver)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := c.client.Watch(ctx, v3Prefix(prefix), clientv3.WithPrefix(), clientv3.WithRev(int64(waitIndex)))
+
+	for {
+		var response clientv3.WatchResponse
+		var ok bool
+		select {
+		case <-stop:
+			return nil, etcd.ErrWatchStoppedByUser
+		case response, ok = <-changes:
+		}
+		if !ok {
+			return nil, fmt.Errorf("etcd v3 watch closed")
+		}
+		if response.CompactRevision != 0 {
+			return nil, etcd.EtcdError{ErrorCode: etcdErrorIndexCleared, Message: "revision compacted", Index: uint64(response.CompactRevision)}
+		}
+		if err := response.Err(); err != nil {
+			return nil, err
+		}
+
+		for _, change := range v3Changes(response.Events) {
+			select {
+			case receiver <- change:
+			case <-stop:
+				return nil, etcd.ErrWatchStoppedByUser
+			}
+		}
+	}
+}
+
+// Turns v3 events into the v2 responses the watcher applies.
+func v3Changes(events []*clientv3.Event) []*etcd.Response {
+	changes := make([]*etcd.Response, 0, len(events))
+	for _, event := range events {
+		action := "set"
+		if event.Type == clientv3.EventTypeDelete {
+			action = "delete"
+		}
+		node := &etcd.Node{Key: "/" + cleanKey(string(event.Kv.Key)), Value: string(event.Kv.Value), ModifiedIndex: uint64(event.Kv.ModRevision)}
+		changes = append(changes, &etcd.Response{Action: action, Node: node})
+	}
+	return changes
+}
+
+// The v3 key prefix matching everything inside the directory dir, but not
+// its siblings sharing the name (like /rails_app02 for /rails_app0). The
+// prefix is kept as given, so keys written without a leading slash match too.
+func v3Prefix(dir string) string {
+	return strings.TrimRight(dir, "/") + "/"
+}
@@ -0,0 +1,53 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+func TestV3Changes(t *testing.T) {
+	changes := v3Changes([]*clientv3.Event{
+		{Type: clientv3.EventTypePut, Kv: &mvccpb.KeyValue{Key: []byte("/rails/database/host"), Value: []byte("db02"), ModRevision: 12}},
+		{Type: clientv3.EventTypeDelete, Kv: &mvccpb.KeyValue{Key: []byte("/rails/database/pool"), ModRevision: 13}},
+	})
+
+	assert.Equal(t, len(changes), 2)
+	assert.Equal(t, changes[0].Action, "set")
+	assert.Equal(t, changes[0].Node.Key, "/rails/database/host")
+	assert.Equal(t, changes[0].Node.Value, "db02")
+	assert.Equal(t, changes[0].Node.ModifiedIndex, uint64(12))
+	assert.Equal(t, changes[1].Action, "delete")
+	assert.Equal(t, changes[1].Node.ModifiedIndex, uint64(13))
+
+	// the changes update the data like v2 ones
+	env := Env{}
+	data := map[string]interface{}{"database": map[string]interface{}{"host": "db01", "pool": "5"}}
+	for _, change := range changes {
+		env.UpdateData(env.KeyParts(change.Node.Key, "/rails"), change.Node.Value, change.Action, data)
+	}
+	assert.Equal(t, data, map[string]interface{}{"database": map[string]interface{}{"host": "db02"}})
+}
+
+func TestFlatTree(t *testing.T) {
+	root := flatTree("/rails", []flatKey{
+		{Key: "/rails/database/host", Value: "db01"},
+		{Key: "/rails/servers/1", Value: "b"},
+		{Key: "/rails/servers/0", Value: "a"},
+		{Key: "/rails/empty/", Dir: true},
+	})
+
+	env := Env{}
+	data := map[string]interface{}{}
+	env.BuildData(*root, "/rails", data)
+	assert.Equal(t, data, map[string]interface{}{
+		"database": map[string]interface{}{"host": "db01"},
+		"servers":  []interface{}{"a", "b"},
+		"empty":    map[string]interface{}{},
+	})
+	assert.Equal(t, v3Prefix("rails/"), "rails/")
+	assert.Equal(t, v3Prefix("rails"), "rails/")
+	assert.Equal(t, v3Prefix("/rails"), "/rails/")
+}
@@ -0,0 +1,106 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type ExecReloader struct {
+	Command *string
+}
+
+// Runs the reload command through the shell. The rendered file is passed in
+// the RAILS_CONFIGD_FILE environment variable, the keys that changed in
+// RAILS_CONFIGD_CHANGED_KEYS (comma separated), and the command output ends
+// up in the log. A nonzero exit status, or running longer than -reload-timeout,
+// fails the reload. A command timing out gets a SIGTERM, along with everything
+// it started, and a SIGKILL if it's still there -drain-timeout later.
+func (reloader *ExecReloader) Reload(env Env) error {
+	return runCommand(env, "EXEC RELOADER", *reloader.Command, env.ReloadTimeout, env.drainTimeout())
+}
+
+func (reloader *ExecReloader) RegisterFlags() {
+	reloader.Command = flag.String("reload-command", "", "The shell command to run when we need to reload")
+}
+
+func (reloader *ExecReloader) Open() error {
+	if *reloader.Command == "" {
+		return fmt.Errorf("-reload-command is required")
+	}
+	return nil
+}
+
+// How long a command gets to exit after SIGTERM without a DrainTimeout
+const defaultDrainTimeout = 10 * time.Second
+
+// Runs command through the shell with RAILS_CONFIGD_FILE set to the rendered
+// file and RAILS_CONFIGD_CHANGED_KEYS to the ChangedKeys, logging its output
+// under tag. Fails on a nonzero exit status or when it runs longer than
+// timeout (unless it's 0). The command runs in its own process group, so on
+// timeout everything it started gets a SIGTERM, then a SIGKILL if it's still
+// running drain later.
+func runCommand(env Env, tag string, command string, timeout time.Duration, drain time.Duration) error {
+	env.Logger.Infof("[%s] Running %s", tag, command)
+
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), "RAILS_CONFIGD_FILE="+env.OutputFile(), "RAILS_CONFIGD_CHANGED_KEYS="+strings.Join(env.ChangedKeys, ","))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	timedOut := false
+	select {
+	case err = <-done:
+	case <-expired:
+		timedOut = true
+		group := -cmd.Process.Pid
+		env.Logger.Warnf("[%s] %s still running after %s, sending SIGTERM", tag, command, timeout)
+		syscall.Kill(group, syscall.SIGTERM)
+		select {
+		case err = <-done:
+		case <-time.After(drain):
+			env.Logger.Warnf("[%s] %s still running %s after SIGTERM, sending SIGKILL", tag, command, drain)
+			syscall.Kill(group, syscall.SIGKILL)
+			err = <-done
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out.Bytes()))
+	for scanner.Scan() {
+		env.Logger.Infof("[%s] %s", tag, scanner.Text())
+	}
+
+	if timedOut {
+		return fmt.Errorf("%s timed out after %s", command, timeout)
+	}
+	return err
+}
+
+func init() {
+	execReloader := ExecReloader{}
+	RegisterReloader("exec", &execReloader)
+}
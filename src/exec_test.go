@@ -0,0 +1,38 @@
+package src
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestExecReload(t *testing.T) {
+	yamlFile := "config/database.yml"
+	env := Env{Renderer: &YamlRenderer{YamlFile: &yamlFile}, ChangedKeys: []string{"database/host", "pool"}}
+
+	command, timeout := `test "$RAILS_CONFIGD_FILE" = config/database.yml -a "$RAILS_CONFIGD_CHANGED_KEYS" = database/host,pool`, time.Second
+	reloader := ExecReloader{Command: &command}
+	env.ReloadTimeout = timeout
+	assert.Equal(t, reloader.Reload(env), nil)
+
+	command = "echo failing; exit 3"
+	assert.NotEqual(t, reloader.Reload(env), nil)
+
+	command, env.ReloadTimeout = "exec sleep 5", 50*time.Millisecond
+	assert.NotEqual(t, reloader.Reload(env), nil)
+}
+
+func TestExecReloadKillsProcessGroup(t *testing.T) {
+	// the child ignores SIGTERM and keeps the output open, so the reload only
+	// returns early once the whole group is killed
+	command, timeout, drain := `sh -c 'trap "" TERM; sleep 5' & wait`, 200*time.Millisecond, 100*time.Millisecond
+	reloader := ExecReloader{Command: &command}
+
+	start := time.Now()
+	err := reloader.Reload(Env{ReloadTimeout: timeout, DrainTimeout: drain})
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, strings.Contains(err.Error(), "timed out after 200ms"), true)
+	assert.Equal(t, time.Since(start) < 2*time.Second, true)
+}
@@ -0,0 +1,297 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Serializes the data with the renderer and writes it to every sink of Output
+// (or OutputFile), with the Header if the format has comments. Reports whether
+// the file changed. Each file of a MultiRenderer is written, even if writing
+// another one fails, and the one at OutputFile goes to every sink. Nothing is
+// written when the renderer runs past RenderTimeout.
+func (env *Env) render() (bool, error) {
+	if renderer, ok := env.Renderer.(MultiRenderer); ok {
+		var files map[string][]byte
+		err := env.withRenderTimeout(func(rendered Env) (err error) {
+			files, err = renderer.RenderFiles(rendered)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+
+		paths := make([]string, 0, len(files))
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		changed := false
+		for _, path := range paths {
+			if path == env.OutputFile() {
+				written, writeErr := env.writeSinks(path, false, files[path])
+				if err == nil {
+					err = writeErr
+				}
+				changed = changed || written
+				continue
+			}
+			written, writeErr := env.writeConfig(path, files[path])
+			if writeErr != nil {
+				env.Logger.Errorf("[ENV] Cannot write %s: %s", path, writeErr)
+				if err == nil {
+					err = writeErr
+				}
+			}
+			changed = changed || written
+		}
+		return changed, err
+	}
+
+	var out []byte
+	err := env.withRenderTimeout(func(rendered Env) (err error) {
+		out, err = env.Renderer.Render(rendered)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	renderer, ok := env.Renderer.(CommentedRenderer)
+	return env.writeSinks(env.OutputFile(), ok && renderer.Commented(), out)
+}
+
+// A renderer still running past RenderTimeout.
+type renderTimeoutError struct {
+	timeout time.Duration
+}
+
+func (err renderTimeoutError) Error() string {
+	return fmt.Sprintf("the renderer took longer than the -render-timeout of %s", err.timeout)
+}
+
+// Runs render with a copy of the Env, giving up on it past RenderTimeout. Go
+// can't stop the renderer, so it's left to finish in the background with its
+// own copy of the data, and its result is dropped. A panic of the renderer is
+// raised again here.
+func (env *Env) withRenderTimeout(render func(Env) error) error {
+	if env.RenderTimeout <= 0 {
+		return render(*env)
+	}
+
+	rendered := *env
+	rendered.Data, _ = copyData(env.Data).(map[string]interface{})
+	done := make(chan error, 1)
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				panicked <- r
+			}
+		}()
+		done <- render(rendered)
+	}()
+
+	timer := time.NewTimer(env.RenderTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case r := <-panicked:
+		panic(r)
+	case <-timer.C:
+		return renderTimeoutError{env.RenderTimeout}
+	}
+}
+
+// Writes the rendered configuration to path, unless the file already holds
+// exactly the same bytes. Reports whether the file changed, so Cycle can skip
+// reloading the Rails processes when nothing changed. Configurations that
+// don't parse back are never written, and with Backup the previous file is
+// backed up first. With DryRun the configuration is printed to stdout and the
+// file is left alone. A path of "-" always writes to stdout.
+func (env *Env) writeConfig(path string, out []byte) (bool, error) {
+	return env.writeFile(path, nil, out)
+}
+
+// Like writeConfig, but with Header the file starts with a comment saying
+// it's generated. Used for renderers whose format has # comments. The header is
+// left out when comparing with (and diffing against) the current file, so its
+// timestamp alone never changes it.
+func (env *Env) writeCommented(path string, out []byte) (bool, error) {
+	if !env.Header {
+		return env.writeConfig(path, out)
+	}
+
+	changedAt := env.ChangedAt
+	if changedAt.IsZero() {
+		changedAt = time.Now()
+	}
+	from := ""
+	if env.EtcdDir != nil {
+		from = " from " + *env.EtcdDir
+	}
+	header := fmt.Sprintf("%s at %s%s — DO NOT EDIT\n", headerPrefix, changedAt.UTC().Format(time.RFC3339), from)
+
+	return env.writeFile(path, []byte(header), out)
+}
+
+const headerPrefix = "# Generated by rails-configd"
+
+// Removes the header writeCommented put at the start of a file.
+func stripHeader(current []byte) []byte {
+	if !bytes.HasPrefix(current, []byte(headerPrefix)) {
+		return current
+	}
+	if i := bytes.IndexByte(current, '\n'); i >= 0 {
+		return current[i+1:]
+	}
+	return nil
+}
+
+func (env *Env) writeFile(path string, header, out []byte) (bool, error) {
+	if path == "-" {
+		_, err := os.Stdout.Write(append(header, out...))
+		return true, err
+	}
+
+	current, err := ioutil.ReadFile(path)
+	previous := current
+	if header != nil {
+		previous = stripHeader(current)
+	}
+	if err == nil && bytes.Equal(previous, out) {
+		env.Status.SetValidation(nil)
+		return false, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	diff := out
+	out = append(header, out...)
+
+	err = validateConfig(path, out)
+	env.Status.SetValidation(err)
+	if err != nil {
+		return false, err
+	}
+
+	if env.ShowDiff {
+		// the previous file holds the secrets rendered last time
+		mask := env.lineMasker(append(env.secretValues(), env.renderedSecrets...))
+		env.Logger.Infof("[DIFF] %s changed:\n%s", path, unifiedDiff(path, path, previous, diff, mask))
+	}
+
+	if env.DryRun {
+		env.Logger.Infof("[DRY RUN] Would write %s:", path)
+		_, err := os.Stdout.Write(out)
+		return true, err
+	}
+
+	if env.Backup && current != nil {
+		if err := env.backup(path, current); err != nil {
+			env.Logger.Warnf("[ENV] Cannot back up %s: %s", path, err)
+		}
+	}
+
+	uid, gid, err := lookupOwner(env.FileOwner, env.FileGroup)
+	if err != nil {
+		return false, err
+	}
+	if env.FileMode != 0 {
+		err = replaceFile(path, out, env.FileMode, uid, gid)
+	} else {
+		err = writeFileAtomic(path, out, 0644, uid, gid)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Writes data to a temporary file next to path and renames it into place, so
+// readers never see a half-written file. The temporary file takes the mode of
+// the file it replaces (or perm for new files). On failure the previous file
+// is left untouched.
+func writeFileAtomic(path string, data []byte, perm os.FileMode, uid, gid int) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return replaceFile(path, data, perm, uid, gid)
+}
+
+// Like writeFileAtomic, but always with the perm mode. The temporary file gets
+// its mode and owner (unless uid and gid are -1) before any data is written,
+// so secrets are never readable by anyone else.
+func replaceFile(path string, data []byte, perm os.FileMode, uid, gid int) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+
+	err = file.Chmod(perm)
+	if err == nil && (uid != -1 || gid != -1) {
+		if err = file.Chown(uid, gid); os.IsPermission(err) {
+			err = fmt.Errorf("cannot change the owner of %s, rails-configd needs to run as root for -file-owner and -file-group", path)
+		}
+	}
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// Turns the -file-owner and -file-group user and group names (or ids) into
+// ids. Empty names give -1, which leaves the owner unchanged.
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+
+	if owner != "" {
+		id := owner
+		if _, err := strconv.Atoi(owner); err != nil {
+			found, err := user.Lookup(owner)
+			if err != nil {
+				return 0, 0, err
+			}
+			id = found.Uid
+		}
+		uid, _ = strconv.Atoi(id)
+	}
+	if group != "" {
+		id := group
+		if _, err := strconv.Atoi(group); err != nil {
+			found, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, err
+			}
+			id = found.Gid
+		}
+		gid, _ = strconv.Atoi(id)
+	}
+
+	return uid, gid, nil
+}
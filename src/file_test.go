@@ -0,0 +1,199 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yml")
+	assert.Equal(t, writeFileAtomic(file, []byte("first"), 0644, -1, -1), nil)
+
+	info, _ := os.Stat(file)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0644))
+
+	// the replacement keeps the permissions of the previous file
+	os.Chmod(file, 0600)
+	assert.Equal(t, writeFileAtomic(file, []byte("second"), 0644, -1, -1), nil)
+
+	out, _ := ioutil.ReadFile(file)
+	assert.Equal(t, string(out), "second")
+	info, _ = os.Stat(file)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0600))
+
+	// no temporary files are left behind
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, len(files), 1)
+}
+
+func TestWriteConfigStdout(t *testing.T) {
+	reader, writer, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	env := Env{}
+	changed, err := env.writeConfig("-", []byte("pool: 5\n"))
+	writer.Close()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, true)
+
+	out, _ := ioutil.ReadAll(reader)
+	assert.Equal(t, string(out), "pool: 5\n")
+	_, err = os.Stat("-")
+	assert.Equal(t, os.IsNotExist(err), true)
+}
+
+func TestWriteConfigFileMode(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yml")
+	ioutil.WriteFile(file, []byte("old"), 0644)
+
+	env := Env{FileMode: 0600}
+	changed, err := env.writeConfig(file, []byte("password: hunter2\n"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, true)
+
+	info, _ := os.Stat(file)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0600))
+}
+
+func TestLookupOwner(t *testing.T) {
+	uid, gid, err := lookupOwner("", "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, uid, -1)
+	assert.Equal(t, gid, -1)
+
+	uid, gid, err = lookupOwner("1000", "50")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, uid, 1000)
+	assert.Equal(t, gid, 50)
+
+	_, _, err = lookupOwner("no-such-user-rails-configd", "")
+	assert.NotEqual(t, err, nil)
+}
+
+func TestWriteCommented(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yml")
+	etcdDir := "/rails"
+	env := Env{Header: true, EtcdDir: &etcdDir, ChangedAt: time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)}
+
+	changed, err := env.writeCommented(file, []byte("pool: 5\n"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, true)
+	out, _ := ioutil.ReadFile(file)
+	assert.Equal(t, string(out), "# Generated by rails-configd at 2016-03-01T12:00:00Z from /rails — DO NOT EDIT\npool: 5\n")
+
+	// a new timestamp alone doesn't change the file
+	env.ChangedAt = env.ChangedAt.Add(time.Hour)
+	changed, err = env.writeCommented(file, []byte("pool: 5\n"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, false)
+
+	changed, err = env.writeCommented(file, []byte("pool: 10\n"))
+	assert.Equal(t, changed, true)
+	out, _ = ioutil.ReadFile(file)
+	assert.Equal(t, string(out), "# Generated by rails-configd at 2016-03-01T13:00:00Z from /rails — DO NOT EDIT\npool: 10\n")
+
+	// without Header the file is written as is
+	env.Header = false
+	env.writeCommented(file, []byte("pool: 10\n"))
+	out, _ = ioutil.ReadFile(file)
+	assert.Equal(t, string(out), "pool: 10\n")
+}
+
+func TestRender(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	dotenvFile := filepath.Join(dir, ".env")
+	jsonFile := filepath.Join(dir, "config.json")
+	env := Env{Header: true, Data: map[string]interface{}{"pool": "5"}, ChangedAt: time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)}
+
+	// formats with # comments get the header
+	env.Renderer = &DotenvRenderer{DotenvFile: &dotenvFile}
+	changed, err := env.render()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, true)
+	out, _ := ioutil.ReadFile(dotenvFile)
+	assert.Equal(t, string(out), "# Generated by rails-configd at 2016-03-01T12:00:00Z — DO NOT EDIT\nPOOL=5\n")
+
+	env.Renderer = &JsonRenderer{JsonFile: &jsonFile}
+	changed, err = env.render()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, true)
+	out, _ = ioutil.ReadFile(jsonFile)
+	assert.Equal(t, string(out), "{\n  \"pool\": \"5\"\n}\n")
+
+	changed, err = env.render()
+	assert.Equal(t, changed, false)
+}
+
+func TestRenderSinks(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	reader, writer, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	jsonFile := filepath.Join(dir, "config.json")
+	copyFile := filepath.Join(dir, "copy.json")
+	missingFile := filepath.Join(dir, "missing", "config.json")
+	renderer := "config/config.json"
+	env := Env{Renderer: &JsonRenderer{JsonFile: &renderer}, Data: map[string]interface{}{"pool": "5"}}
+	env.Output = missingFile + "," + jsonFile + ", -," + copyFile
+	assert.Equal(t, env.OutputFile(), missingFile)
+
+	// the file that can't be written doesn't hold back the others
+	changed, err := env.render()
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, changed, true)
+	for _, file := range []string{jsonFile, copyFile} {
+		out, _ := ioutil.ReadFile(file)
+		assert.Equal(t, string(out), "{\n  \"pool\": \"5\"\n}\n")
+	}
+
+	// stdout always gets it, but only files count as changes
+	env.Output = jsonFile + ",-"
+	changed, err = env.render()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, false)
+
+	env.Output = "-"
+	changed, err = env.render()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, true)
+	assert.Equal(t, env.OutputFile(), "-")
+
+	writer.Close()
+	printed, _ := ioutil.ReadAll(reader)
+	assert.Equal(t, string(printed), strings.Repeat("{\n  \"pool\": \"5\"\n}\n", 3))
+
+	// stdout failing is only logged
+	env.Output = jsonFile + ",-"
+	changed, err = env.render()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, false)
+}
+
+func TestValidateOutput(t *testing.T) {
+	assert.Equal(t, ValidateOutput(""), nil)
+	assert.Equal(t, ValidateOutput("config/database.yml,-,syslog://local0,syslog://"), nil)
+	assert.NotEqual(t, ValidateOutput("config/database.yml,syslog://nowhere"), nil)
+}
@@ -0,0 +1,20 @@
+package src
+
+import "strings"
+
+// ListFlag is a flag.Value for lists, given either comma separated or by
+// repeating the flag.
+type ListFlag []string
+
+func (list *ListFlag) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *ListFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*list = append(*list, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package src
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Flattens value into out for the renderers of flat formats, under tag in the
+// log. Nested keys are the segments, renamed by rename, joined with
+// FlattenSeparator or else separator, and lists use the element index as the
+// segment. A segment holding the separator itself makes the flattened keys
+// ambiguous (a/b_c and a_b/c both give a_b_c), which is logged.
+func (env *Env) flatten(tag string, value interface{}, prefix string, separator string, rename func(string) string, out map[string]string) {
+	if env.FlattenSeparator != "" {
+		separator = env.FlattenSeparator
+	}
+
+	ambiguous := make(map[string]bool)
+	flattenData(value, prefix, separator, rename, out, ambiguous)
+
+	keys := make([]string, 0, len(ambiguous))
+	for key := range ambiguous {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env.Logger.Warnf("[%s] Key %q holds the separator %q, its flattened keys are ambiguous", tag, key, separator)
+	}
+}
+
+func flattenData(value interface{}, prefix string, separator string, rename func(string) string, out map[string]string, ambiguous map[string]bool) {
+	join := func(key string) string {
+		if rename != nil {
+			key = rename(key)
+		}
+		if strings.Contains(key, separator) {
+			ambiguous[key] = true
+		}
+		if prefix == "" {
+			return key
+		}
+		return prefix + separator + key
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			flattenData(child, join(key), separator, rename, out, ambiguous)
+		}
+	case []interface{}:
+		for i, child := range value {
+			flattenData(child, join(fmt.Sprint(i)), separator, rename, out, ambiguous)
+		}
+	case nil:
+	default:
+		out[prefix] = fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,173 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type HclRenderer struct {
+	HclFile *string
+}
+
+// Renders the data as an HCL2 body: nested maps become blocks and other
+// values attributes, written first and sorted to keep the file stable between
+// cycles. Lists (from directories with numeric keys) become tuples, and maps
+// inside them objects. Strings are quoted, while numbers, booleans and null
+// (from -coerce-types) are written as such. Keys of blocks and attributes
+// have to be HCL identifiers.
+func (renderer *HclRenderer) Render(env Env) ([]byte, error) {
+	path := env.outputPath(renderer.File())
+	env.Logger.Infof("[HCL RENDERER] Rendering to %s", path)
+
+	var out bytes.Buffer
+	if err := writeHclBody(&out, env.Data, ""); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// Writes the attributes, then the blocks, of a body.
+func writeHclBody(out *bytes.Buffer, body map[string]interface{}, indent string) error {
+	var attributes, blocks []string
+	for key, value := range body {
+		if !hclIdentifier.MatchString(key) {
+			return fmt.Errorf("%q can't be an HCL identifier", key)
+		}
+		if _, ok := value.(map[string]interface{}); ok {
+			blocks = append(blocks, key)
+		} else {
+			attributes = append(attributes, key)
+		}
+	}
+	sort.Strings(attributes)
+	sort.Strings(blocks)
+
+	for _, key := range attributes {
+		out.WriteString(indent + key + " = ")
+		writeHclValue(out, body[key], indent)
+		out.WriteString("\n")
+	}
+	for i, key := range blocks {
+		if i > 0 || len(attributes) > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString(indent + key + " {\n")
+		if err := writeHclBody(out, body[key].(map[string]interface{}), indent+"  "); err != nil {
+			return err
+		}
+		out.WriteString(indent + "}\n")
+	}
+	return nil
+}
+
+func writeHclValue(out *bytes.Buffer, value interface{}, indent string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			out.WriteString("{}")
+			return
+		}
+
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		out.WriteString("{\n")
+		for _, key := range keys {
+			name := key
+			if !hclIdentifier.MatchString(key) {
+				name = hclQuote(key)
+			}
+			out.WriteString(indent + "  " + name + " = ")
+			writeHclValue(out, value[key], indent+"  ")
+			out.WriteString("\n")
+		}
+		out.WriteString(indent + "}")
+	case []interface{}:
+		if len(value) == 0 {
+			out.WriteString("[]")
+			return
+		}
+
+		out.WriteString("[\n")
+		for _, child := range value {
+			out.WriteString(indent + "  ")
+			writeHclValue(out, child, indent+"  ")
+			out.WriteString(",\n")
+		}
+		out.WriteString(indent + "]")
+	case nil:
+		out.WriteString("null")
+	case bool:
+		out.WriteString(strconv.FormatBool(value))
+	case int, int64:
+		fmt.Fprint(out, value)
+	case float64:
+		out.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	case json.Number:
+		out.WriteString(value.String())
+	case string:
+		out.WriteString(hclQuote(value))
+	default:
+		out.WriteString(hclQuote(fmt.Sprint(value)))
+	}
+}
+
+var hclIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// Double quotes an HCL string. Besides quotes and backslashes, the ${ and %{
+// template sequences are escaped so values are never interpolated, and control
+// characters are written as escapes.
+func hclQuote(value string) string {
+	value = strings.NewReplacer("${", "$${", "%{", "%%{").Replace(value)
+
+	var out bytes.Buffer
+	out.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"', '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&out, `\u%04X`, r)
+			} else {
+				out.WriteRune(r)
+			}
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
+
+func (renderer *HclRenderer) File() string {
+	return *renderer.HclFile
+}
+
+func (renderer *HclRenderer) Commented() bool {
+	return true
+}
+
+func (renderer *HclRenderer) RegisterFlags() {
+	renderer.HclFile = flag.String("hcl-file", "config/config.hcl", "The output of the HCL file")
+}
+
+func init() {
+	hclRenderer := HclRenderer{}
+	RegisterRenderer("hcl", &hclRenderer)
+}
@@ -0,0 +1,59 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestHclRender(t *testing.T) {
+	file := "config.hcl"
+	renderer := HclRenderer{HclFile: &file}
+
+	data := map[string]interface{}{
+		"name": "myapp",
+		"database": map[string]interface{}{
+			"pool":    int64(5),
+			"timeout": 2.5,
+			"ssl":     true,
+			"primary": map[string]interface{}{"host": "db01"},
+			"empty":   map[string]interface{}{},
+		},
+		"servers": []interface{}{"web01", map[string]interface{}{"host": "web02", "max-conns": "10", "0": nil}},
+		"motd":    "hello \"${user}\"\n",
+	}
+	out, err := renderer.Render(Env{Data: data})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `motd = "hello \"$${user}\"\n"
+name = "myapp"
+servers = [
+  "web01",
+  {
+    "0" = null
+    host = "web02"
+    max-conns = "10"
+  },
+]
+
+database {
+  pool = 5
+  ssl = true
+  timeout = 2.5
+
+  empty {
+  }
+
+  primary {
+    host = "db01"
+  }
+}
+`)
+}
+
+func TestHclRenderInvalidKeys(t *testing.T) {
+	file := "config.hcl"
+	renderer := HclRenderer{HclFile: &file}
+
+	_, err := renderer.Render(Env{Data: map[string]interface{}{"database": map[string]interface{}{"5pool": "5"}}})
+	assert.Equal(t, err.Error(), `"5pool" can't be an HCL identifier`)
+}
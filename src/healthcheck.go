@@ -0,0 +1,57 @@
+package src
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// How often the HealthcheckUrl is polled after a reload
+var healthcheckInterval = 500 * time.Millisecond
+
+// The app didn't come back healthy after a reload.
+type unhealthyError struct {
+	err error
+}
+
+func (err unhealthyError) Error() string {
+	return err.err.Error()
+}
+
+// Polls HealthcheckUrl until it answers 2xx, failing once HealthcheckTimeout
+// is over.
+func (env *Env) waitHealthy() error {
+	deadline := time.Now().Add(env.HealthcheckTimeout)
+	client := &http.Client{Timeout: env.HealthcheckTimeout}
+
+	env.Logger.Infof("[HEALTHCHECK] Waiting for %s to answer", env.HealthcheckUrl)
+	for {
+		err := checkHealth(client, env.HealthcheckUrl)
+		if err == nil {
+			env.Logger.Infof("[HEALTHCHECK] %s is healthy", env.HealthcheckUrl)
+			return nil
+		}
+		if time.Now().Add(healthcheckInterval).After(deadline) {
+			err = fmt.Errorf("the app is still unhealthy %s after reloading, %s", env.HealthcheckTimeout, err)
+			env.Logger.Errorf("[HEALTHCHECK] !!! %s", err)
+			return unhealthyError{err}
+		}
+		time.Sleep(healthcheckInterval)
+	}
+}
+
+func checkHealth(client *http.Client, url string) error {
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", url, response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package src
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestReloadHealthcheck(t *testing.T) {
+	healthcheckInterval = time.Millisecond
+	defer func() { healthcheckInterval = 500 * time.Millisecond }()
+
+	requests, healthyFrom := 0, 3
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < healthyFrom {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	// healthy on the third try
+	env := Env{Reloader: new(MockReloader), HealthcheckUrl: server.URL, HealthcheckTimeout: time.Second}
+	assert.Equal(t, env.reload(), nil)
+	assert.Equal(t, requests, 3)
+
+	// never healthy
+	healthyFrom = 1000
+	env.HealthcheckTimeout = 20 * time.Millisecond
+	err := env.reload()
+	_, unhealthy := err.(unhealthyError)
+	assert.T(t, unhealthy)
+}
+
+func TestRollbackOnUnhealthy(t *testing.T) {
+	healthcheckInterval = time.Millisecond
+	defer func() { healthcheckInterval = 500 * time.Millisecond }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(file, []byte("{\"pool\": \"5\"}\n"), 0644)
+
+	reloader := new(KeysReloader)
+	env := Env{
+		Data:                map[string]interface{}{"pool": "10"},
+		Renderer:            &JsonRenderer{JsonFile: &file},
+		Reloader:            reloader,
+		HealthcheckUrl:      server.URL,
+		HealthcheckTimeout:  10 * time.Millisecond,
+		RollbackOnUnhealthy: true,
+		Status:              new(Status),
+	}
+	err := env.Cycle()
+	assert.NotEqual(t, err, nil)
+
+	// the previous file is back, and reloaded
+	out, _ := ioutil.ReadFile(file)
+	assert.Equal(t, string(out), "{\"pool\": \"5\"}\n")
+	assert.Equal(t, len(reloader.Reloads), 2)
+	assert.Equal(t, *env.Status.Report(true).LastReloadError, err.Error())
+}
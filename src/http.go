@@ -0,0 +1,61 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Starts the HTTP server with the health check endpoints:
+//
+//   /healthz - 200 while the etcd watch is connected and the last render succeeded,
+//              with a JSON StatusReport in the body
+//   /readyz  - 200 once the initial render is done
+//
+// Both answer 503 otherwise. Prometheus metrics are exported on /metrics.
+func StartHTTP(addr string, status *Status) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		report(w, status)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		probe(w, status.Ready())
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[HTTP] Listening on %s", listener.Addr())
+	go func() {
+		log.Printf("[HTTP] Server stopped: %s", http.Serve(listener, mux))
+	}()
+
+	return nil
+}
+
+func report(w http.ResponseWriter, status *Status) {
+	healthy := status.Healthy()
+	out, _ := json.MarshalIndent(status.Report(healthy), "", "  ")
+
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(append(out, '\n'))
+}
+
+func probe(w http.ResponseWriter, ok bool) {
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "unavailable")
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,83 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestProbe(t *testing.T) {
+	status := new(Status)
+
+	assert.Equal(t, status.Healthy(), false)
+	assert.Equal(t, status.Ready(), false)
+
+	status.SetConnected(true)
+	status.SetRender(nil)
+	status.SetReady()
+	assert.Equal(t, status.Healthy(), true)
+	assert.Equal(t, status.Ready(), true)
+
+	status.SetRender(errors.New("boom"))
+	assert.Equal(t, status.Healthy(), false)
+
+	w := httptest.NewRecorder()
+	probe(w, status.Healthy())
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+	assert.Equal(t, w.Body.String(), "unavailable\n")
+}
+
+func TestReport(t *testing.T) {
+	status := new(Status)
+
+	w := httptest.NewRecorder()
+	report(w, status)
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+	assert.Equal(t, w.Body.String(), `{
+  "status": "unavailable",
+  "etcdConnected": false,
+  "lastRenderTime": null,
+  "lastRenderError": null,
+  "lastReloadTime": null,
+  "lastReloadError": null,
+  "eventCount": 0,
+  "renderTimeouts": 0
+}
+`)
+
+	status.SetConnected(true)
+	status.SetRender(nil)
+	status.SetReload(nil)
+	status.AddEvent()
+	status.AddEvent()
+	status.SetReload(errors.New("exit status 1"))
+
+	w = httptest.NewRecorder()
+	report(w, status)
+	assert.Equal(t, w.Code, http.StatusOK)
+	var body StatusReport
+	assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &body), nil)
+	assert.Equal(t, body.Status, "ok")
+	assert.Equal(t, body.EtcdConnected, true)
+	assert.T(t, body.LastRenderTime != nil)
+	assert.Equal(t, body.LastRenderError, (*string)(nil))
+	assert.T(t, body.LastReloadTime != nil)
+	assert.Equal(t, *body.LastReloadError, "exit status 1")
+	assert.Equal(t, body.EventCount, 2)
+
+	// the last render failed, but the time of the last success is kept
+	status.SetRender(errors.New("boom"))
+	status.SetValidation(errors.New("config.yml would not be valid YAML"))
+	w = httptest.NewRecorder()
+	report(w, status)
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+	assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &body), nil)
+	assert.Equal(t, body.Status, "unavailable")
+	assert.Equal(t, body.Reason, "config.yml would not be valid YAML")
+	assert.Equal(t, *body.LastRenderError, "boom")
+	assert.T(t, body.LastRenderTime != nil)
+}
@@ -0,0 +1,105 @@
+package src
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type IniRenderer struct {
+	IniFile *string
+}
+
+// Renders the data as an INI file: top-level directories become [section]s
+// holding their keys, while top-level values come first, outside any
+// section. Anything nested deeper is flattened into dotted keys (or joined
+// with -flatten-separator), so database/primary/host becomes primary.host in
+// the [database] section, and lists use the element index, like servers.0.
+// Sections and keys are sorted to keep the file stable between cycles.
+func (renderer *IniRenderer) Render(env Env) ([]byte, error) {
+	path := env.outputPath(renderer.File())
+	env.Logger.Infof("[INI RENDERER] Rendering to %s", path)
+
+	globals := make(map[string]string)
+	sections := make(map[string]map[string]string)
+	for key, value := range env.Data {
+		if section, ok := value.(map[string]interface{}); ok {
+			sections[key] = make(map[string]string)
+			env.flatten("INI RENDERER", section, "", ".", nil, sections[key])
+		} else {
+			env.flatten("INI RENDERER", value, key, ".", nil, globals)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeIniKeys(&out, globals); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i > 0 || len(globals) > 0 {
+			out.WriteString("\n")
+		}
+		if strings.ContainsAny(name, "[]\n\r") {
+			return nil, fmt.Errorf("%q can't be an INI section name", name)
+		}
+		fmt.Fprintf(&out, "[%s]\n", name)
+		if err := writeIniKeys(&out, sections[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+func writeIniKeys(out *bytes.Buffer, values map[string]string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if key == "" || strings.ContainsAny(key, "=;#[]\"\n\r") || strings.TrimSpace(key) != key {
+			return fmt.Errorf("%q can't be an INI key", key)
+		}
+		fmt.Fprintf(out, "%s = %s\n", key, iniQuote(values[key]))
+	}
+	return nil
+}
+
+// Double quotes values that INI parsers would otherwise trim, cut at a
+// comment or split over lines. Backslashes and quotes are escaped.
+func iniQuote(value string) string {
+	if strings.TrimSpace(value) == value && !strings.ContainsAny(value, ";#\"\\\n\r") {
+		return value
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+func (renderer *IniRenderer) File() string {
+	return *renderer.IniFile
+}
+
+func (renderer *IniRenderer) Commented() bool {
+	return true
+}
+
+func (renderer *IniRenderer) RegisterFlags() {
+	renderer.IniFile = flag.String("ini-file", "config/config.ini", "The output of the INI file")
+}
+
+func init() {
+	iniRenderer := IniRenderer{}
+	RegisterRenderer("ini", &iniRenderer)
+}
@@ -0,0 +1,48 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestIniRender(t *testing.T) {
+	file := "config.ini"
+	renderer := IniRenderer{IniFile: &file}
+
+	data := map[string]interface{}{
+		"name": "myapp",
+		"database": map[string]interface{}{
+			"pool":     int64(5),
+			"password": "p4ss;word#1",
+			"primary":  map[string]interface{}{"host": "db01"},
+			"replicas": []interface{}{"db02", "db03"},
+		},
+		"cache": map[string]interface{}{"motd": " hello\n\"world\" "},
+	}
+	out, err := renderer.Render(Env{Data: data})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `name = myapp
+
+[cache]
+motd = " hello\n\"world\" "
+
+[database]
+password = "p4ss;word#1"
+pool = 5
+primary.host = db01
+replicas.0 = db02
+replicas.1 = db03
+`)
+}
+
+func TestIniRenderInvalidKeys(t *testing.T) {
+	file := "config.ini"
+	renderer := IniRenderer{IniFile: &file}
+
+	_, err := renderer.Render(Env{Data: map[string]interface{}{"database": map[string]interface{}{"a=b": "c"}}})
+	assert.Equal(t, err.Error(), `"a=b" can't be an INI key`)
+
+	_, err = renderer.Render(Env{Data: map[string]interface{}{"[db]": map[string]interface{}{"pool": "5"}}})
+	assert.Equal(t, err.Error(), `"[db]" can't be an INI section name`)
+}
@@ -0,0 +1,139 @@
+package src
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Returns the data to render: Data itself, or with InterpolateEnv a copy where
+// ${VAR} and $VAR references in string values are replaced by the process
+// environment. $$ is a literal $. Unknown variables are empty, or an error
+// with InterpolateStrict. Expanded values are coerced again with CoerceTypes.
+// With a Vault resolver, values that are vault: references are replaced by
+// the secrets they point to.
+func (env *Env) interpolated() (map[string]interface{}, error) {
+	if (!env.InterpolateEnv && env.Vault == nil) || env.Data == nil {
+		return env.Data, nil
+	}
+
+	data, err := env.interpolate(env.Data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return data.(map[string]interface{}), nil
+}
+
+func (env *Env) interpolate(value interface{}, parts []string) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			interpolated, err := env.interpolate(child, append(parts[:len(parts):len(parts)], key))
+			if err != nil {
+				return nil, err
+			}
+			copied[key] = interpolated
+		}
+		return copied, nil
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, child := range value {
+			interpolated, err := env.interpolate(child, append(parts[:len(parts):len(parts)], fmt.Sprint(i)))
+			if err != nil {
+				return nil, err
+			}
+			copied[i] = interpolated
+		}
+		return copied, nil
+	case string:
+		expanded := value
+		if env.InterpolateEnv {
+			var err error
+			if expanded, err = expandEnv(value, env.InterpolateStrict); err != nil {
+				return nil, fmt.Errorf("cannot interpolate %s: %s", strings.Join(parts, "/"), err)
+			}
+		}
+		if env.Vault != nil && strings.HasPrefix(expanded, vaultPrefix) {
+			secret, err := env.Vault.Resolve(expanded, env.Logger)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve %s: %s", strings.Join(parts, "/"), err)
+			}
+			return secret, nil
+		}
+		if expanded != value && env.CoerceTypes && !matchKey(env.StringKeys, parts) {
+			return coerceValue(expanded), nil
+		}
+		return expanded, nil
+	default:
+		return value, nil
+	}
+}
+
+// Expands ${VAR} and $VAR from the process environment. A $ that doesn't
+// start a reference (including an unterminated ${) is kept as is.
+func expandEnv(value string, strict bool) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+
+	var out bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i == len(value)-1 {
+			out.WriteByte(value[i])
+			continue
+		}
+
+		var name string
+		next := value[i+1]
+		switch {
+		case next == '$':
+			out.WriteByte('$')
+			i++
+			continue
+		case next == '{':
+			end := strings.IndexByte(value[i+2:], '}')
+			if end < 0 || !isEnvName(value[i+2:i+2+end]) {
+				out.WriteByte('$')
+				continue
+			}
+			name = value[i+2 : i+2+end]
+			i += end + 2
+		default:
+			end := i + 1
+			for end < len(value) && isEnvNameByte(value[end], end == i+1) {
+				end++
+			}
+			if end == i+1 {
+				out.WriteByte('$')
+				continue
+			}
+			name = value[i+1 : end]
+			i = end - 1
+		}
+
+		expanded, ok := os.LookupEnv(name)
+		if !ok && strict {
+			return "", fmt.Errorf("$%s isn't set", name)
+		}
+		out.WriteString(expanded)
+	}
+	return out.String(), nil
+}
+
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isEnvNameByte(name[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvNameByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (!first && c >= '0' && c <= '9')
+}
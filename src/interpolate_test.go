@@ -0,0 +1,69 @@
+package src
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("RAILS_CONFIGD_TEST_HOST", "db01")
+	defer os.Unsetenv("RAILS_CONFIGD_TEST_HOST")
+	os.Unsetenv("RAILS_CONFIGD_TEST_MISSING")
+
+	for value, expected := range map[string]string{
+		"plain":                                 "plain",
+		"${RAILS_CONFIGD_TEST_HOST}:5432":       "db01:5432",
+		"postgres://$RAILS_CONFIGD_TEST_HOST/x": "postgres://db01/x",
+		"costs $$5":                             "costs $5",
+		"$$RAILS_CONFIGD_TEST_HOST":             "$RAILS_CONFIGD_TEST_HOST",
+		"[${RAILS_CONFIGD_TEST_MISSING}]":       "[]",
+		"trailing $":                            "trailing $",
+		"${unterminated":                        "${unterminated",
+		"$1 and ${}":                            "$1 and ${}",
+	} {
+		expanded, err := expandEnv(value, false)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, expanded, expected)
+	}
+
+	_, err := expandEnv("${RAILS_CONFIGD_TEST_MISSING}", true)
+	assert.Equal(t, err.Error(), "$RAILS_CONFIGD_TEST_MISSING isn't set")
+}
+
+func TestInterpolated(t *testing.T) {
+	os.Setenv("RAILS_CONFIGD_TEST_HOST", "db01")
+	os.Setenv("RAILS_CONFIGD_TEST_POOL", "5")
+	defer os.Unsetenv("RAILS_CONFIGD_TEST_HOST")
+	defer os.Unsetenv("RAILS_CONFIGD_TEST_POOL")
+
+	env := Env{InterpolateEnv: true, CoerceTypes: true, Data: map[string]interface{}{
+		"database": map[string]interface{}{
+			"host":    "${RAILS_CONFIGD_TEST_HOST}",
+			"pool":    "$RAILS_CONFIGD_TEST_POOL",
+			"timeout": int64(5),
+		},
+		"replicas": []interface{}{"$RAILS_CONFIGD_TEST_HOST", "db02"},
+	}}
+
+	data, err := env.interpolated()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, data, map[string]interface{}{
+		"database": map[string]interface{}{"host": "db01", "pool": int64(5), "timeout": int64(5)},
+		"replicas": []interface{}{"db01", "db02"},
+	})
+
+	// the data itself keeps the references
+	assert.Equal(t, env.Data["database"].(map[string]interface{})["host"], "${RAILS_CONFIGD_TEST_HOST}")
+
+	env.InterpolateStrict = true
+	env.Data["database"].(map[string]interface{})["password"] = "${RAILS_CONFIGD_TEST_MISSING}"
+	_, err = env.interpolated()
+	assert.Equal(t, err.Error(), "cannot interpolate database/password: $RAILS_CONFIGD_TEST_MISSING isn't set")
+
+	// failing to interpolate fails the render
+	env.Renderer = new(MockRenderer)
+	assert.NotEqual(t, env.Cycle(), nil)
+	assert.Equal(t, env.Renderer.(*MockRenderer).Called, false)
+}
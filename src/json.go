@@ -0,0 +1,49 @@
+package src
+
+import (
+	"encoding/json"
+	"flag"
+	"strings"
+)
+
+type JsonRenderer struct {
+	JsonFile *string
+}
+
+// Renders the data as a JSON document indented by -indent spaces.
+// encoding/json already sorts map keys, so the same data always produces the
+// same file.
+func (renderer *JsonRenderer) Render(env Env) ([]byte, error) {
+	path := env.outputPath(renderer.File())
+	env.Logger.Infof("[JSON RENDERER] Rendering to %s", path)
+
+	data, err := env.sectionData(path, "JSON", parseJsonSections)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		// render an empty object rather than null
+		data = map[string]interface{}{}
+	}
+
+	out, err := json.MarshalIndent(data, "", strings.Repeat(" ", env.indent()))
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, '\n')
+
+	return out, nil
+}
+
+func (renderer *JsonRenderer) File() string {
+	return *renderer.JsonFile
+}
+
+func (renderer *JsonRenderer) RegisterFlags() {
+	renderer.JsonFile = flag.String("json-file", "config/config.json", "The output of the JSON file")
+}
+
+func init() {
+	jsonRenderer := JsonRenderer{}
+	RegisterRenderer("json", &jsonRenderer)
+}
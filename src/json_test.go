@@ -0,0 +1,59 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestJsonRender(t *testing.T) {
+	file := "config.json"
+	renderer := JsonRenderer{JsonFile: &file}
+
+	data := map[string]interface{}{
+		"mongodb": map[string]interface{}{"port": "27017", "hostname": "localhost"},
+		"empty":   map[string]interface{}{},
+	}
+	out, err := renderer.Render(Env{Data: data})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `{
+  "empty": {},
+  "mongodb": {
+    "hostname": "localhost",
+    "port": "27017"
+  }
+}
+`)
+}
+
+func TestJsonRenderOutput(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.json")
+	output := filepath.Join(dir, "database.json")
+	renderer := JsonRenderer{JsonFile: &file}
+
+	env := Env{Data: map[string]interface{}{"pool": "5"}, Output: output, Renderer: &renderer}
+	_, err := env.render()
+	assert.Equal(t, err, nil)
+
+	out, _ := ioutil.ReadFile(output)
+	assert.Equal(t, string(out), "{\n  \"pool\": \"5\"\n}\n")
+	assert.Equal(t, env.OutputFile(), output)
+
+	_, err = os.Stat(file)
+	assert.Equal(t, os.IsNotExist(err), true)
+}
+
+func TestJsonRenderIndent(t *testing.T) {
+	file := "config.json"
+	renderer := JsonRenderer{JsonFile: &file}
+
+	out, err := renderer.Render(Env{Data: map[string]interface{}{"database": map[string]interface{}{"pool": "5"}}, Indent: 4})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), "{\n    \"database\": {\n        \"pool\": \"5\"\n    }\n}\n")
+}
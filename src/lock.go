@@ -0,0 +1,46 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Lock is an exclusive lock on a file, held until released or until the
+// process exits.
+type Lock struct {
+	file *os.File
+}
+
+// Takes the lock on path, creating the file if needed, so only one
+// rails-configd at a time manages the same files. Fails right away if another
+// process holds it. The file holds the pid of the process holding the lock.
+func AcquireLock(path string) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("another rails-configd is already running (%s is locked)", path)
+		}
+		return nil, err
+	}
+
+	file.Truncate(0)
+	file.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+
+	return &Lock{file: file}, nil
+}
+
+// Releases the lock.
+func (lock *Lock) Release() error {
+	if err := syscall.Flock(int(lock.file.Fd()), syscall.LOCK_UN); err != nil {
+		lock.file.Close()
+		return err
+	}
+	return lock.file.Close()
+}
@@ -0,0 +1,27 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestLock(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rails-configd.lock")
+	lock, err := AcquireLock(path)
+	assert.Equal(t, err, nil)
+
+	_, err = AcquireLock(path)
+	assert.NotEqual(t, err, nil)
+
+	assert.Equal(t, lock.Release(), nil)
+	lock, err = AcquireLock(path)
+	assert.Equal(t, err, nil)
+	lock.Release()
+}
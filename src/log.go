@@ -0,0 +1,159 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Structured fields attached to a log message
+type Fields map[string]interface{}
+
+// Matches the "[TAG] message" (or "[TAG]: message") convention of our logs
+var tagPattern = regexp.MustCompile(`^\[([^\]]+)\]:? ?(.*)$`)
+
+// Set when logging as JSON
+var jsonLog *jsonLogWriter
+
+// How important a log message is
+type Level int
+
+const (
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{LevelDebug: "debug", LevelInfo: "info", LevelWarn: "warn", LevelError: "error"}
+
+// Parses a level name: debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if levelName == strings.ToLower(name) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+func (level Level) String() string {
+	return levelNames[level]
+}
+
+// Logger drops messages below its level. A nil Logger logs at info level.
+type Logger struct {
+	Level Level
+}
+
+func (logger *Logger) Debugf(format string, args ...interface{}) {
+	logger.Log(LevelDebug, nil, format, args...)
+}
+
+func (logger *Logger) Infof(format string, args ...interface{}) {
+	logger.Log(LevelInfo, nil, format, args...)
+}
+
+func (logger *Logger) Warnf(format string, args ...interface{}) {
+	logger.Log(LevelWarn, nil, format, args...)
+}
+
+func (logger *Logger) Errorf(format string, args ...interface{}) {
+	logger.Log(LevelError, nil, format, args...)
+}
+
+// Logs a message with structured fields, if level is high enough.
+func (logger *Logger) Log(level Level, fields Fields, format string, args ...interface{}) {
+	minimum := LevelInfo
+	if logger != nil {
+		minimum = logger.Level
+	}
+	if level < minimum {
+		return
+	}
+
+	if jsonLog != nil {
+		withLevel := Fields{"level": level.String()}
+		for key, value := range fields {
+			withLevel[key] = value
+		}
+		fields = withLevel
+	}
+	LogFields(fields, format, args...)
+}
+
+// Selects how logs are written: "text" (the standard log format) or "json",
+// one object per line with the time, tag, message and any extra fields.
+func SetLogFormat(format string) error {
+	return setLogFormat(format, os.Stderr)
+}
+
+func setLogFormat(format string, out io.Writer) error {
+	switch format {
+	case "text":
+		jsonLog = nil
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(out)
+	case "json":
+		jsonLog = &jsonLogWriter{out: out}
+		log.SetFlags(0)
+		log.SetOutput(jsonLog)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	return nil
+}
+
+// Logs a message along with structured fields. The fields only show up in the
+// JSON format, so the message should still make sense on its own.
+func LogFields(fields Fields, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+
+	if jsonLog != nil {
+		jsonLog.write(message, fields)
+	} else {
+		log.Print(message)
+	}
+}
+
+// Turns each log line into a JSON object
+type jsonLogWriter struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+func (writer *jsonLogWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		writer.write(line, nil)
+	}
+	return len(p), nil
+}
+
+func (writer *jsonLogWriter) write(message string, fields Fields) {
+	entry := Fields{}
+	for key, value := range fields {
+		entry[key] = value
+	}
+
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["msg"] = message
+	if match := tagPattern.FindStringSubmatch(message); match != nil {
+		entry["tag"] = match[1]
+		entry["msg"] = match[2]
+	}
+
+	out, err := json.Marshal(entry)
+	if err != nil {
+		out, _ = json.Marshal(Fields{"time": entry["time"], "msg": message})
+	}
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.out.Write(append(out, '\n'))
+}
@@ -0,0 +1,65 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestJsonLogFormat(t *testing.T) {
+	var out bytes.Buffer
+	assert.Equal(t, setLogFormat("json", &out), nil)
+	defer setLogFormat("text", os.Stderr)
+
+	log.Printf("[MAIN] Waiting for changes")
+	LogFields(Fields{"action": "set", "key": "db/pool"}, "[CHANGE]: %s %s", "set", "db/pool")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, len(lines), 2)
+
+	var entry map[string]interface{}
+	assert.Equal(t, json.Unmarshal([]byte(lines[0]), &entry), nil)
+	assert.Equal(t, entry["tag"], "MAIN")
+	assert.Equal(t, entry["msg"], "Waiting for changes")
+
+	entry = nil
+	assert.Equal(t, json.Unmarshal([]byte(lines[1]), &entry), nil)
+	assert.Equal(t, entry["tag"], "CHANGE")
+	assert.Equal(t, entry["msg"], "set db/pool")
+	assert.Equal(t, entry["key"], "db/pool")
+	assert.NotEqual(t, entry["time"], nil)
+
+	assert.NotEqual(t, setLogFormat("xml", &out), nil)
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var out bytes.Buffer
+	assert.Equal(t, setLogFormat("json", &out), nil)
+	defer setLogFormat("text", os.Stderr)
+
+	logger := &Logger{Level: LevelWarn}
+	logger.Infof("[ENV] Configuration didn't change")
+	logger.Warnf("[ENV] Reload failed, retrying")
+	assert.Equal(t, strings.Count(out.String(), "\n"), 1)
+
+	var entry map[string]interface{}
+	assert.Equal(t, json.Unmarshal(out.Bytes(), &entry), nil)
+	assert.Equal(t, entry["level"], "warn")
+
+	out.Reset()
+	var nilLogger *Logger
+	nilLogger.Debugf("[CHANGE]: set db/pool 5")
+	nilLogger.Infof("[ENV] Configuration didn't change")
+	assert.Equal(t, strings.Count(out.String(), "\n"), 1)
+
+	level, err := ParseLevel("DEBUG")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, level, LevelDebug)
+	_, err = ParseLevel("verbose")
+	assert.NotEqual(t, err, nil)
+}
@@ -0,0 +1,100 @@
+package src
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	etcdEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rails_configd_etcd_events_total",
+		Help: "Number of etcd changes processed.",
+	})
+	renders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rails_configd_renders_total",
+		Help: "Number of renders attempted, by result (success, failure or timeout).",
+	}, []string{"result"})
+	reloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rails_configd_reloads_total",
+		Help: "Number of reloads attempted, by result (success or failure).",
+	}, []string{"result"})
+	resyncDrifts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rails_configd_resync_drifts_total",
+		Help: "Number of periodic resyncs that found the data out of sync with etcd.",
+	})
+	etcdConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rails_configd_etcd_connected",
+		Help: "Whether the etcd watch is connected (1) or not (0).",
+	})
+	reconnectAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "rails_configd_etcd_reconnect_attempts_total",
+		Help: "Number of attempts to reconnect to etcd.",
+	})
+	failingReconnectAttempts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rails_configd_etcd_reconnect_attempts",
+		Help: "Number of attempts of the reconnect to etcd in progress, 0 once connected.",
+	})
+
+	lastRenderMutex   sync.Mutex
+	lastRenderSuccess time.Time
+)
+
+func init() {
+	secondsSinceRender := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "rails_configd_seconds_since_last_render",
+		Help: "Seconds since the configuration was last rendered successfully.",
+	}, func() float64 {
+		lastRenderMutex.Lock()
+		defer lastRenderMutex.Unlock()
+
+		if lastRenderSuccess.IsZero() {
+			return 0
+		}
+		return time.Since(lastRenderSuccess).Seconds()
+	})
+
+	prometheus.MustRegister(etcdEvents, renders, reloads, resyncDrifts, etcdConnected, reconnectAttempts, failingReconnectAttempts, secondsSinceRender)
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+func observeRender(err error) {
+	result := resultLabel(err)
+	if _, timeout := err.(renderTimeoutError); timeout {
+		result = "timeout"
+	}
+	renders.WithLabelValues(result).Inc()
+
+	if err == nil {
+		lastRenderMutex.Lock()
+		lastRenderSuccess = time.Now()
+		lastRenderMutex.Unlock()
+	}
+}
+
+func observeReload(err error) {
+	reloads.WithLabelValues(resultLabel(err)).Inc()
+}
+
+func observeConnected(connected bool) {
+	if connected {
+		etcdConnected.Set(1)
+	} else {
+		etcdConnected.Set(0)
+	}
+}
+
+// Counts the attempt of a reconnect, or its success when attempt is 0.
+func observeReconnectAttempt(attempt int) {
+	if attempt > 0 {
+		reconnectAttempts.Inc()
+	}
+	failingReconnectAttempts.Set(float64(attempt))
+}
@@ -0,0 +1,68 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// SlackNotifier posts a message to a Slack incoming webhook whenever the
+// rendered file changes, listing the keys that changed since the last one.
+// It's purely informational: messages are sent in the background and
+// failures are only logged.
+type SlackNotifier struct {
+	Url string
+	// How long to wait for Slack to answer
+	Timeout time.Duration
+}
+
+func NewSlackNotifier(url string) *SlackNotifier {
+	return &SlackNotifier{Url: url, Timeout: 10 * time.Second}
+}
+
+// Posts text as a Slack message.
+func (notifier *SlackNotifier) Send(text string) error {
+	out, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: notifier.Timeout}
+	response, err := client.Post(notifier.Url, "application/json", bytes.NewReader(out))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("slack answered %s", response.Status)
+	}
+	return nil
+}
+
+// Tells Slack the file was rendered with the given changes, without waiting
+// for the answer.
+func (env *Env) notify(changes []string) {
+	file := env.OutputFile()
+	if file == "" || file == "-" {
+		file = "the configuration"
+	}
+	host, _ := os.Hostname()
+	text := fmt.Sprintf("rails-configd updated %s on %s", file, host)
+	for _, change := range changes {
+		text += "\n• " + change
+	}
+
+	notifier, logger := env.Notifier, env.Logger
+	go func() {
+		if err := notifier.Send(text); err != nil {
+			logger.Warnf("[NOTIFY] Cannot notify Slack: %s", err)
+		}
+	}()
+}
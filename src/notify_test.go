@@ -0,0 +1,96 @@
+package src
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestNotify(t *testing.T) {
+	messages := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		messages <- payload["text"]
+	}))
+	defer server.Close()
+
+	env := Env{
+		Renderer:   new(MockRenderer),
+		Reloader:   new(MockReloader),
+		SecretKeys: DefaultSecretKeys,
+		Notifier:   NewSlackNotifier(server.URL),
+	}
+	env.recordChange([]string{"production", "host"}, "db01", "set")
+	env.recordChange([]string{"production", "password"}, "hunter2", "set")
+	env.recordChange([]string{"production", "pool"}, "", "delete")
+	assert.Equal(t, env.Cycle(), nil)
+
+	lines := strings.Split(<-messages, "\n")
+	assert.T(t, strings.HasPrefix(lines[0], "rails-configd updated the configuration on "))
+	assert.Equal(t, lines[1:], []string{
+		"• set production/host = db01",
+		"• set production/password = ***",
+		"• delete production/pool",
+	})
+
+	// an unchanged file isn't announced, and forgets the changes
+	env.recordChange([]string{"production", "host"}, "db01", "set")
+	renderer := unchangedRenderer()
+	defer os.RemoveAll(filepath.Dir(renderer.Path))
+	env.Renderer = renderer
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, len(env.changes), 0)
+	assert.Equal(t, len(messages), 0)
+}
+
+func TestNotifyAfterReload(t *testing.T) {
+	messages := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		messages <- payload["text"]
+	}))
+	defer server.Close()
+
+	reloader := &FlakyReloader{Failures: 1}
+	env := Env{Renderer: new(MockRenderer), Reloader: reloader, Notifier: NewSlackNotifier(server.URL)}
+
+	// a failed reload isn't announced, its changes are with the next one
+	env.recordChange([]string{"pool"}, "10", "set")
+	assert.NotEqual(t, env.Cycle(), nil)
+	env.recordChange([]string{"host"}, "db02", "set")
+	assert.Equal(t, env.Cycle(), nil)
+
+	lines := strings.Split(<-messages, "\n")
+	assert.Equal(t, lines[1:], []string{"• set pool = 10", "• set host = db02"})
+	assert.Equal(t, len(messages), 0)
+
+	// neither is a render that partly failed
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+	env.Output = filepath.Join(dir, "missing", "config.yml") + "," + filepath.Join(dir, "config.yml")
+	env.recordChange([]string{"pool"}, "15", "set")
+	assert.NotEqual(t, env.Cycle(), nil)
+	assert.Equal(t, reloader.Calls, 3)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, len(messages), 0)
+}
+
+func TestNotifyFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := NewSlackNotifier(server.URL).Send("hello")
+	assert.Equal(t, err.Error(), "slack answered 404 Not Found")
+}
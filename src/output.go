@@ -0,0 +1,143 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+const syslogScheme = "syslog://"
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// Where the rendered configuration goes: the comma separated sinks of Output
+// (file paths, "-" for stdout and syslog://facility), or else path.
+func (env *Env) outputs(path string) []string {
+	if env.Output == "" {
+		return []string{path}
+	}
+
+	var sinks []string
+	for _, sink := range strings.Split(env.Output, ",") {
+		if sink = strings.TrimSpace(sink); sink != "" {
+			sinks = append(sinks, sink)
+		}
+	}
+	return sinks
+}
+
+// Checks the syslog sinks of an -output.
+func ValidateOutput(output string) error {
+	for _, sink := range (&Env{Output: output}).outputs("") {
+		if strings.HasPrefix(sink, syslogScheme) {
+			if _, err := syslogFacility(sink); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fileSink(sink string) bool {
+	return sink != "-" && !strings.HasPrefix(sink, syslogScheme)
+}
+
+// Writes the rendered configuration to every sink, whatever happens to the
+// others. Files are only written when they changed, and only they tell
+// whether the configuration changed: stdout and syslog get every rendered
+// configuration, and without files it always counts as changed. Likewise only
+// a file failing to be written fails the render, stdout and syslog failures
+// are just logged.
+func (env *Env) writeSinks(path string, commented bool, out []byte) (bool, error) {
+	changed, files := false, false
+	var err error
+	for _, sink := range env.outputs(path) {
+		var written bool
+		var writeErr error
+		switch {
+		case strings.HasPrefix(sink, syslogScheme):
+			writeErr = env.writeSyslog(sink, out)
+		case commented:
+			written, writeErr = env.writeCommented(sink, out)
+		default:
+			written, writeErr = env.writeConfig(sink, out)
+		}
+
+		if writeErr != nil {
+			env.Logger.Errorf("[ENV] Cannot write %s: %s", sink, writeErr)
+		}
+		if fileSink(sink) {
+			files = true
+			changed = changed || written
+			if err == nil {
+				err = writeErr
+			}
+		}
+	}
+	if !files {
+		changed = true
+	}
+	return changed, err
+}
+
+// Sends the rendered configuration to syslog, a message per line, at the
+// info level of the facility of sink.
+func (env *Env) writeSyslog(sink string, out []byte) error {
+	facility, err := syslogFacility(sink)
+	if err != nil {
+		return err
+	}
+	if env.DryRun {
+		env.Logger.Infof("[DRY RUN] Would send the configuration to %s", sink)
+		return nil
+	}
+
+	writer, err := syslog.New(facility|syslog.LOG_INFO, "rails-configd")
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if _, err := writer.Write(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syslogFacility(sink string) (syslog.Priority, error) {
+	name := strings.TrimPrefix(sink, syslogScheme)
+	if name == "" {
+		return syslog.LOG_USER, nil
+	}
+	facility, ok := syslogFacilities[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q in %s", name, sink)
+	}
+	return facility, nil
+}
@@ -0,0 +1,95 @@
+package src
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+)
+
+type PropertiesRenderer struct {
+	PropertiesFile *string
+}
+
+// Renders the data as a Java .properties file. Nested keys are joined with
+// dots (or -flatten-separator), so database/pool becomes database.pool, and
+// arrays use the element index, so servers/0 becomes servers.0. Keys and
+// values are escaped the way java.util.Properties stores them, non ASCII
+// characters included, and lines are sorted to keep the file stable between
+// cycles.
+func (renderer *PropertiesRenderer) Render(env Env) ([]byte, error) {
+	path := env.outputPath(renderer.File())
+	env.Logger.Infof("[PROPERTIES RENDERER] Rendering to %s", path)
+
+	props := make(map[string]string)
+	env.flatten("PROPERTIES RENDERER", env.Data, "", ".", nil, props)
+
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var out bytes.Buffer
+	for _, key := range keys {
+		fmt.Fprintf(&out, "%s=%s\n", propertiesEscape(key, true), propertiesEscape(props[key], false))
+	}
+
+	return out.Bytes(), nil
+}
+
+func (renderer *PropertiesRenderer) File() string {
+	return *renderer.PropertiesFile
+}
+
+func (renderer *PropertiesRenderer) Commented() bool {
+	return true
+}
+
+func (renderer *PropertiesRenderer) RegisterFlags() {
+	renderer.PropertiesFile = flag.String("properties-file", "config/application.properties", "The output of the .properties file")
+}
+
+// Escapes a key (where every space must be escaped) or a value (where only a
+// leading one must) like Properties.store: backslashes, separators, comment
+// characters and whitespace get a backslash, and anything outside printable
+// ASCII becomes \uXXXX, as UTF-16 surrogate pairs beyond the BMP.
+func propertiesEscape(value string, key bool) string {
+	var out bytes.Buffer
+	for i, r := range value {
+		switch r {
+		case ' ':
+			if key || i == 0 {
+				out.WriteByte('\\')
+			}
+			out.WriteByte(' ')
+		case '\\', '=', ':', '#', '!':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\f':
+			out.WriteString(`\f`)
+		default:
+			if r >= 0x20 && r <= 0x7e {
+				out.WriteRune(r)
+			} else if r > 0xffff {
+				high, low := utf16.EncodeRune(r)
+				fmt.Fprintf(&out, `\u%04X\u%04X`, high, low)
+			} else {
+				fmt.Fprintf(&out, `\u%04X`, r)
+			}
+		}
+	}
+	return out.String()
+}
+
+func init() {
+	propertiesRenderer := PropertiesRenderer{}
+	RegisterRenderer("properties", &propertiesRenderer)
+}
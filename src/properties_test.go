@@ -0,0 +1,85 @@
+package src
+
+import (
+	"strconv"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestPropertiesRender(t *testing.T) {
+	file := "application.properties"
+	renderer := PropertiesRenderer{PropertiesFile: &file}
+
+	data := map[string]interface{}{
+		"database": map[string]interface{}{"pool": int64(5), "url": "jdbc:postgresql://db01/app"},
+		"servers":  []interface{}{"web01", "web02"},
+		"greeting": " hello = world",
+		"city":     "Zürich",
+	}
+	out, err := renderer.Render(Env{Data: data})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `city=Z\u00FCrich
+database.pool=5
+database.url=jdbc\:postgresql\://db01/app
+greeting=\ hello \= world
+servers.0=web01
+servers.1=web02
+`)
+
+	out, err = renderer.Render(Env{Data: data, FlattenSeparator: "/"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `city=Z\u00FCrich
+database/pool=5
+database/url=jdbc\:postgresql\://db01/app
+greeting=\ hello \= world
+servers/0=web01
+servers/1=web02
+`)
+}
+
+func TestPropertiesEscapeRoundTrip(t *testing.T) {
+	for _, value := range []string{
+		"plain",
+		" leading and trailing ",
+		"a=b:c#d!e",
+		`C:\rails`,
+		"tab\tnewline\ncarriage\rfeed\f",
+		"Zürich ☃ 😀",
+	} {
+		assert.Equal(t, propertiesUnescape(propertiesEscape(value, false)), value)
+		assert.Equal(t, propertiesUnescape(propertiesEscape(value, true)), value)
+	}
+}
+
+// Reads an escaped key or value back the way java.util.Properties loads it.
+func propertiesUnescape(escaped string) string {
+	var units []uint16
+	for i := 0; i < len(escaped); i++ {
+		c := escaped[i]
+		if c != '\\' {
+			units = append(units, uint16(c))
+			continue
+		}
+
+		i++
+		switch escaped[i] {
+		case 't':
+			units = append(units, '\t')
+		case 'n':
+			units = append(units, '\n')
+		case 'r':
+			units = append(units, '\r')
+		case 'f':
+			units = append(units, '\f')
+		case 'u':
+			unit, _ := strconv.ParseUint(escaped[i+1:i+5], 16, 16)
+			units = append(units, uint16(unit))
+			i += 4
+		default:
+			units = append(units, uint16(escaped[i]))
+		}
+	}
+	return string(utf16.Decode(units))
+}
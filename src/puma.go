@@ -0,0 +1,91 @@
+package src
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type PumaReloader struct {
+	PidFile      *string
+	ControlUrl   *string
+	ControlToken *string
+}
+
+// Phased restart of a Puma cluster, so workers pick up the new config one at
+// a time without dropping connections. When -puma-control-url is set the
+// phased-restart command is issued through the Puma control app first; if
+// that fails (or no control URL is set) SIGUSR1 is sent to the master in
+// -puma-pidfile.
+func (reloader *PumaReloader) Reload(env Env) error {
+	if *reloader.ControlUrl != "" {
+		err := reloader.control(env, "phased-restart")
+		if err == nil {
+			return nil
+		}
+		env.Logger.Warnf("[PUMA RELOADER] Control app failed, falling back to SIGUSR1: %s", err)
+	}
+
+	pid, err := readPidFile(*reloader.PidFile)
+	if err != nil {
+		return err
+	}
+
+	env.Logger.Infof("[PUMA RELOADER] Sending USR1 to master %d", pid)
+	return signalProcess(pid, syscall.SIGUSR1)
+}
+
+func (reloader *PumaReloader) RegisterFlags() {
+	reloader.PidFile = flag.String("puma-pidfile", "tmp/pids/puma.pid", "The pidfile of the Puma master")
+	reloader.ControlUrl = flag.String("puma-control-url", "", "The Puma control app URL (tcp://, http:// or unix://), preferred over signals when set")
+	reloader.ControlToken = flag.String("puma-control-token", "", "The Puma control app token")
+}
+
+// Sends a command to the Puma control app.
+func (reloader *PumaReloader) control(env Env, command string) error {
+	control, err := url.Parse(*reloader.ControlUrl)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	base := "http://" + control.Host
+
+	switch control.Scheme {
+	case "tcp", "http":
+	case "unix":
+		socket := control.Path
+		client.Transport = &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		}
+		base = "http://puma"
+	default:
+		return fmt.Errorf("unsupported control URL scheme %q", control.Scheme)
+	}
+
+	query := url.Values{"token": {*reloader.ControlToken}}
+	env.Logger.Infof("[PUMA RELOADER] Sending %s to %s", command, *reloader.ControlUrl)
+
+	response, err := client.Get(base + "/" + command + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("control app answered %s", strings.TrimSpace(response.Status))
+	}
+	return nil
+}
+
+func init() {
+	pumaReloader := PumaReloader{}
+	RegisterReloader("puma", &pumaReloader)
+}
@@ -0,0 +1,30 @@
+package src
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestPumaControl(t *testing.T) {
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.RequestURI()
+		if r.URL.Query().Get("token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	controlUrl, token, pidFile := server.URL, "secret", "/nonexistent/puma.pid"
+	reloader := PumaReloader{PidFile: &pidFile, ControlUrl: &controlUrl, ControlToken: &token}
+
+	assert.Equal(t, reloader.Reload(Env{}), nil)
+	assert.Equal(t, requested, "/phased-restart?token=secret")
+
+	// a failing control app falls back to the pidfile, which is missing here
+	token = "wrong"
+	assert.NotEqual(t, reloader.Reload(Env{}), nil)
+}
@@ -0,0 +1,176 @@
+package src
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReloadQueue reloads the Rails app once for the changes of several watches.
+// Each watch renders its own file and requests a reload, and the queue reloads
+// once the requests stop coming for Debounce. Without Debounce (when each
+// watch debounces its own changes) it reloads right after a request, and the
+// requests made during a reload are merged into the next one. With Cooldown,
+// reloads are at least that far apart: requests made in between are merged
+// into a single reload once the cooldown is over. With Stagger, each reload
+// waits a random delay up to it, so several instances watching the same
+// directory don't all reload at the same time.
+type ReloadQueue struct {
+	// The Env whose Reloader and retry settings are used
+	Env *Env
+	// Reload only after no requests arrived for this long
+	Debounce time.Duration
+	// Never reload more often than this
+	Cooldown time.Duration
+	// Delay each reload by a random duration up to this
+	Stagger time.Duration
+
+	requests   chan bool
+	lastReload time.Time
+	// The keys changed since the last reload, unless a request didn't know
+	// them
+	keys        []string
+	keysUnknown bool
+	// The Env of the last request as it was then, so reloading doesn't read
+	// it while its watcher updates it
+	env   *Env
+	mutex sync.Mutex
+}
+
+func NewReloadQueue(env *Env) *ReloadQueue {
+	return &ReloadQueue{Env: env, requests: make(chan bool, 1)}
+}
+
+// Asks for a reload of the file env rendered, for the keys that changed (none
+// when they aren't known). Requests made before the reload happens are merged,
+// reloading with the Env of the last one.
+func (queue *ReloadQueue) Request(env *Env, keys ...string) {
+	snapshot := *env
+	snapshot.Data, _ = copyData(env.Data).(map[string]interface{})
+
+	queue.mutex.Lock()
+	queue.env = &snapshot
+	if len(keys) == 0 {
+		queue.keysUnknown = true
+	}
+	queue.keys = append(queue.keys, keys...)
+	queue.mutex.Unlock()
+
+	select {
+	case queue.requests <- true:
+	default:
+	}
+}
+
+// Reloads right away if a reload was requested.
+func (queue *ReloadQueue) Flush() error {
+	select {
+	case <-queue.requests:
+		queue.lastReload = time.Now()
+		return queue.reloadEnv().reload()
+	default:
+		return nil
+	}
+}
+
+// Reloads after each request (or burst of requests, with Debounce), waiting
+// for the Cooldown to pass since the previous reload, until stop is closed.
+func (queue *ReloadQueue) Run(stop chan bool) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-queue.requests:
+		}
+
+		for quiet := false; !quiet && queue.Debounce > 0; {
+			select {
+			case <-stop:
+				return
+			case <-queue.requests:
+			case <-time.After(queue.Debounce):
+				quiet = true
+			}
+		}
+
+		if wait := queue.Cooldown - time.Since(queue.lastReload); queue.Cooldown > 0 && wait > 0 {
+			queue.Env.Logger.Infof("[ENV] Reloaded less than %s ago, reloading again in %s", queue.Cooldown, wait)
+			if !queue.wait(wait, stop) {
+				return
+			}
+		}
+		if queue.Stagger > 0 {
+			wait := time.Duration(rand.Int63n(int64(queue.Stagger)))
+			queue.Env.Logger.Infof("[ENV] Reloading in %s (-reload-stagger)", wait)
+			if !queue.wait(wait, stop) {
+				return
+			}
+		}
+
+		queue.lastReload = time.Now()
+		queue.reload()
+	}
+}
+
+// Waits before reloading, merging the requests made meanwhile into the
+// reload. Returns false if asked to stop in the meantime.
+func (queue *ReloadQueue) wait(delay time.Duration, stop chan bool) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(delay):
+	}
+
+	// requests made meanwhile are covered by this reload
+	select {
+	case <-queue.requests:
+	default:
+	}
+	return true
+}
+
+// The Env to reload with, as of the last request, telling the keys changed
+// since the last reload.
+func (queue *ReloadQueue) reloadEnv() *Env {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	env := *queue.env
+	env.Reloader = queue.Env.Reloader
+	env.ReloadRetries = queue.Env.ReloadRetries
+	env.ReloadBackoff = queue.Env.ReloadBackoff
+	if !queue.keysUnknown {
+		env.ChangedKeys = uniqueKeys(queue.keys)
+	}
+	queue.keys = nil
+	queue.keysUnknown = false
+	return &env
+}
+
+func (queue *ReloadQueue) reload() {
+	defer recoverCycle(queue.Env, queue.Env.Status.SetReload)
+
+	if err := queue.reloadEnv().reload(); err != nil {
+		queue.Env.Logger.Errorf("[ENV] reload failed: %s", err)
+	}
+}
+
+// Parses a -watch value: an etcd directory, the file it's rendered to and
+// optionally the renderer to use instead of -renderer, separated by colons.
+func ParseWatch(watch string) (dir string, file string, renderer string, err error) {
+	parts := strings.SplitN(watch, ":", 3)
+	for _, part := range parts {
+		if part == "" {
+			return "", "", "", fmt.Errorf("invalid watch %q, should be <etcd dir>:<file>[:<renderer>]", watch)
+		}
+	}
+	if len(parts) < 2 {
+		return "", "", "", fmt.Errorf("invalid watch %q, should be <etcd dir>:<file>[:<renderer>]", watch)
+	}
+	if len(parts) == 3 {
+		renderer = parts[2]
+	}
+	return parts[0], parts[1], renderer, nil
+}
@@ -0,0 +1,273 @@
+package src
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+type CountingReloader struct {
+	Calls chan bool
+}
+
+func (r *CountingReloader) Reload(env Env) error {
+	r.Calls <- true
+	return nil
+}
+
+func (r *CountingReloader) RegisterFlags() {
+}
+
+func TestReloadQueue(t *testing.T) {
+	reloader := &CountingReloader{Calls: make(chan bool, 10)}
+	queue := NewReloadQueue(&Env{Reloader: reloader})
+	queue.Debounce = 20 * time.Millisecond
+
+	// watches only request the reload
+	env := Env{Renderer: new(MockRenderer), Reloader: reloader, ReloadQueue: queue}
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, len(reloader.Calls), 0)
+
+	stop := make(chan bool)
+	defer close(stop)
+	go queue.Run(stop)
+
+	<-reloader.Calls
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, len(reloader.Calls), 0)
+
+	queue.Request(queue.Env)
+	<-reloader.Calls
+}
+
+func TestReloadQueueFlush(t *testing.T) {
+	reloader := &CountingReloader{Calls: make(chan bool, 10)}
+	queue := NewReloadQueue(&Env{Reloader: reloader})
+
+	assert.Equal(t, queue.Flush(), nil)
+	assert.Equal(t, len(reloader.Calls), 0)
+
+	queue.Request(queue.Env)
+	queue.Request(queue.Env)
+	assert.Equal(t, queue.Flush(), nil)
+	assert.Equal(t, len(reloader.Calls), 1)
+}
+
+func TestParseWatch(t *testing.T) {
+	dir, file, renderer, err := ParseWatch("/rails/db:config/database.yml")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, dir, "/rails/db")
+	assert.Equal(t, file, "config/database.yml")
+	assert.Equal(t, renderer, "")
+
+	dir, file, renderer, err = ParseWatch("/rails/features:config/features.json:json")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, dir, "/rails/features")
+	assert.Equal(t, file, "config/features.json")
+	assert.Equal(t, renderer, "json")
+
+	for _, watch := range []string{"/rails/db", ":config/database.yml", "/rails/db:", "/rails/db:config/database.yml:"} {
+		_, _, _, err := ParseWatch(watch)
+		assert.NotEqual(t, err, nil)
+	}
+}
+
+func TestReloadQueueCooldown(t *testing.T) {
+	reloader := &CountingReloader{Calls: make(chan bool, 10)}
+	queue := NewReloadQueue(&Env{Reloader: reloader})
+	queue.Cooldown = 100 * time.Millisecond
+
+	stop := make(chan bool)
+	defer close(stop)
+	go queue.Run(stop)
+
+	queue.Request(queue.Env)
+	<-reloader.Calls
+
+	// changes during the cooldown only cause one catch-up reload
+	queue.Request(queue.Env)
+	time.Sleep(10 * time.Millisecond)
+	queue.Request(queue.Env)
+	time.Sleep(40 * time.Millisecond)
+	assert.Equal(t, len(reloader.Calls), 0)
+
+	<-reloader.Calls
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, len(reloader.Calls), 0)
+}
+
+func TestReloadQueueStagger(t *testing.T) {
+	reloader := &CountingReloader{Calls: make(chan bool, 10)}
+	queue := NewReloadQueue(&Env{Reloader: reloader})
+	queue.Stagger = 50 * time.Millisecond
+
+	stop := make(chan bool)
+	defer close(stop)
+	go queue.Run(stop)
+
+	start := time.Now()
+	queue.Request(queue.Env)
+	<-reloader.Calls
+	assert.T(t, time.Since(start) < 100*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, len(reloader.Calls), 0)
+}
+
+func TestReloadQueueChangedKeys(t *testing.T) {
+	reloader := new(KeysReloader)
+	queue := NewReloadQueue(&Env{Reloader: reloader})
+
+	queue.Request(queue.Env, "pool", "database/host")
+	queue.Request(queue.Env, "database/host")
+	assert.Equal(t, queue.Flush(), nil)
+
+	// a request not knowing its keys makes them all unknown
+	queue.Request(queue.Env, "pool")
+	queue.Request(queue.Env)
+	assert.Equal(t, queue.Flush(), nil)
+
+	assert.Equal(t, reloader.Reloads, [][]string{{"database/host", "pool"}, nil})
+	assert.Equal(t, queue.Env.ChangedKeys, []string(nil))
+}
+
+// A reloader remembering the data it reloaded with.
+type DataReloader struct {
+	Reloads []map[string]interface{}
+}
+
+func (r *DataReloader) Reload(env Env) error {
+	r.Reloads = append(r.Reloads, env.Data)
+	return nil
+}
+
+func (r *DataReloader) RegisterFlags() {
+}
+
+func TestReloadQueueSnapshotsEnv(t *testing.T) {
+	reloader := new(DataReloader)
+	env := &Env{Reloader: reloader, Data: map[string]interface{}{"pool": "5"}}
+	queue := NewReloadQueue(env)
+
+	// the watcher updating the data after the request doesn't touch the reload
+	queue.Request(env, "pool")
+	env.Data["pool"] = "10"
+	env.Data = map[string]interface{}{"pool": "15"}
+	assert.Equal(t, queue.Flush(), nil)
+
+	assert.Equal(t, reloader.Reloads, []map[string]interface{}{{"pool": "5"}})
+}
+
+// A reloader remembering the files it reloaded.
+type OutputReloader struct {
+	Files []string
+}
+
+func (r *OutputReloader) Reload(env Env) error {
+	r.Files = append(r.Files, env.OutputFile())
+	return nil
+}
+
+func (r *OutputReloader) RegisterFlags() {
+}
+
+func TestReloadQueueRequestingEnv(t *testing.T) {
+	reloader := new(OutputReloader)
+	mainEnv := &Env{Reloader: reloader, ReloadRetries: 2, Output: "config/app.yml"}
+	queue := NewReloadQueue(mainEnv)
+
+	// each watch reloads with its own file, and the main reloader
+	renderer := &MockRenderer{}
+	for _, output := range []string{"config/database.yml", "config/secrets.yml"} {
+		watch := *mainEnv
+		watch.Renderer, watch.Reloader, watch.Output = renderer, nil, output
+		queue.Request(&watch)
+		assert.Equal(t, queue.Flush(), nil)
+	}
+
+	assert.Equal(t, reloader.Files, []string{"config/database.yml", "config/secrets.yml"})
+}
+
+// A renderer telling which directory it rendered.
+type SignalingRenderer struct {
+	Renders chan string
+}
+
+func (r *SignalingRenderer) Render(env Env) ([]byte, error) {
+	r.Renders <- *env.EtcdDir
+	return nil, nil
+}
+
+func (r *SignalingRenderer) File() string {
+	return "-"
+}
+
+func (r *SignalingRenderer) RegisterFlags() {
+}
+
+// A fake etcd client whose watch sends its event after Delay, then waits to
+// be stopped.
+type StaggeredEtcdClient struct {
+	Delay time.Duration
+	Event *etcd.Response
+}
+
+func (c *StaggeredEtcdClient) SyncCluster() bool {
+	return true
+}
+
+func (c *StaggeredEtcdClient) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	return &etcd.Response{Action: "get", EtcdIndex: 10, Node: &etcd.Node{Key: key, Dir: true}}, nil
+}
+
+func (c *StaggeredEtcdClient) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	defer close(receiver)
+
+	select {
+	case <-time.After(c.Delay):
+		receiver <- c.Event
+	case <-stop:
+	}
+	<-stop
+	return nil, etcd.ErrWatchStoppedByUser
+}
+
+func TestReloadQueuePerFileDebounce(t *testing.T) {
+	reloader := &CountingReloader{Calls: make(chan bool, 10)}
+	queue := NewReloadQueue(&Env{Reloader: reloader})
+
+	stop := make(chan bool)
+	defer close(stop)
+	go queue.Run(stop)
+
+	// the secrets change 60ms after the database, while its window is open
+	watches := []struct {
+		dir   string
+		delay time.Duration
+	}{{"/db", 0}, {"/secrets", 60 * time.Millisecond}}
+	renderer := &SignalingRenderer{Renders: make(chan string, 10)}
+	for _, watch := range watches {
+		dir := watch.dir
+		env := &Env{EtcdDir: &dir, Renderer: renderer, Reloader: reloader, ReloadQueue: queue}
+
+		event := &etcd.Response{Action: "set", Node: &etcd.Node{Key: dir + "/key", Value: "1", ModifiedIndex: 11}}
+		watcher := NewWatcher(&StaggeredEtcdClient{Delay: watch.delay, Event: event}, env)
+		watcher.Debounce = 40 * time.Millisecond
+		assert.Equal(t, watcher.Sync(), nil)
+		go watcher.Run(stop)
+	}
+
+	// the database is rendered and reloaded once its own window closes
+	time.Sleep(80 * time.Millisecond)
+	assert.Equal(t, len(renderer.Renders), 1)
+	assert.Equal(t, <-renderer.Renders, "/db")
+	assert.Equal(t, len(reloader.Calls), 1)
+
+	time.Sleep(80 * time.Millisecond)
+	assert.Equal(t, len(renderer.Renders), 1)
+	assert.Equal(t, <-renderer.Renders, "/secrets")
+	assert.Equal(t, len(reloader.Calls), 2)
+}
@@ -0,0 +1,399 @@
+package src
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// The channels keyspace notifications of the first database are sent to
+const redisKeyspace = "__keyspace@0__:"
+
+// RedisClient reads the string keys under a prefix of a Redis server with
+// SCAN, and watches them through keyspace notifications, presenting them to
+// the watcher as an etcd directory. Redis has no indexes, so the client
+// numbers the changes it sees itself.
+type RedisClient struct {
+	// Address of the Redis server, like 127.0.0.1:6379
+	Addr string
+	// How long connecting and each command may take
+	Timeout time.Duration
+
+	// The values seen last under each prefix, to tell what changed while not
+	// subscribed, as every -watch shares the client
+	values map[string]map[string]string
+	// Number of changes seen so far, counting from 1 as the watcher takes an
+	// index of 0 for one it never synced at
+	index uint64
+	mutex sync.Mutex
+}
+
+// A connection speaking the Redis protocol (RESP).
+type redisConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+func NewRedisClient(addr string) *RedisClient {
+	return &RedisClient{Addr: addr, Timeout: 5 * time.Second, index: 1}
+}
+
+// Checks that the Redis server answers.
+func (c *RedisClient) SyncCluster() bool {
+	conn, err := c.dial()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	reply, err := conn.do("PING")
+	return err == nil && reply == "PONG"
+}
+
+// Reads every string key under the key prefix, as an etcd directory.
+func (c *RedisClient) Get(key string, sorted, recursive bool) (*etcd.Response, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	values, err := conn.list(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.remember(key, values)
+	index := c.index
+	c.mutex.Unlock()
+
+	keys := make([]flatKey, 0, len(values))
+	for key, value := range values {
+		keys = append(keys, flatKey{Key: key, Value: value, Index: index})
+	}
+	return &etcd.Response{Action: "get", EtcdIndex: index, Node: flatTree(key, keys)}, nil
+}
+
+// Sends the changes under prefix to receiver, until stop is closed. Keyspace
+// notifications aren't queued while nobody listens, so once subscribed the
+// keys are listed again, and whatever changed since the last Get (or watch) is
+// sent first. The notifications don't carry the values, so each set key is
+// read back. waitIndex is ignored. The server must have keyspace
+// notifications for string, generic and expired events enabled
+// (notify-keyspace-events K$gx).
+func (c *RedisClient) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	defer close(receiver)
+
+	subscriber, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer subscriber.Close()
+	commands, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer commands.Close()
+
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			subscriber.Close()
+		case <-done:
+		}
+	}()
+
+	if _, err := subscriber.do("PSUBSCRIBE", redisKeyspace+redisPattern(prefix)); err != nil {
+		return nil, err
+	}
+	// notifications come whenever the keys change
+	subscriber.timeout = 0
+	subscriber.conn.SetDeadline(time.Time{})
+
+	values, err := commands.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	changes := redisChanges(c.remember(prefix, values), values, c.index+1)
+	if len(changes) > 0 {
+		c.index++
+	}
+	c.mutex.Unlock()
+
+	for {
+		for _, response := range changes {
+			select {
+			case receiver <- response:
+			case <-stop:
+				return nil, etcd.ErrWatchStoppedByUser
+			}
+		}
+
+		reply, err := subscriber.read()
+		if stopping(stop) {
+			return nil, etcd.ErrWatchStoppedByUser
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// a pmessage: pattern, channel and event
+		message, ok := reply.([]interface{})
+		if !ok || len(message) != 4 || message[0] != "pmessage" {
+			changes = nil
+			continue
+		}
+		channel, _ := message[2].(string)
+		event, _ := message[3].(string)
+		response, err := c.event(commands, prefix, strings.TrimPrefix(channel, redisKeyspace), event)
+		if err != nil {
+			return nil, err
+		}
+		changes = nil
+		if response != nil {
+			changes = append(changes, response)
+		}
+	}
+}
+
+// Turns a keyspace event of a key under prefix into an etcd response, or nil
+// for events that don't change the value of a string key (or changes it didn't
+// see the key before).
+func (c *RedisClient) event(commands *redisConn, prefix string, key string, event string) (*etcd.Response, error) {
+	var action string
+	var value string
+
+	switch event {
+	case "set", "setrange", "append", "incrby", "incrbyfloat", "rename_to":
+		reply, err := commands.do("GET", key)
+		if err != nil {
+			return nil, err
+		}
+		if reply == nil {
+			// already gone, its del event follows
+			return nil, nil
+		}
+		action = "set"
+		value = reply.(string)
+	case "del", "rename_from", "evicted":
+		action = "delete"
+	case "expired":
+		action = "expire"
+	default:
+		return nil, nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	values := c.values[cleanKey(prefix)]
+	if values == nil {
+		values = make(map[string]string)
+		c.remember(prefix, values)
+	}
+	if action == "set" {
+		values[key] = value
+	} else {
+		if _, ok := values[key]; !ok {
+			return nil, nil
+		}
+		delete(values, key)
+	}
+	c.index++
+
+	return &etcd.Response{Action: action, Node: &etcd.Node{Key: "/" + cleanKey(key), Value: value, ModifiedIndex: c.index}}, nil
+}
+
+// Saves the values seen under prefix, returning the ones seen before. The
+// mutex has to be held.
+func (c *RedisClient) remember(prefix string, values map[string]string) map[string]string {
+	if c.values == nil {
+		c.values = make(map[string]map[string]string)
+	}
+	prefix = cleanKey(prefix)
+	previous := c.values[prefix]
+	c.values[prefix] = values
+	return previous
+}
+
+func (c *RedisClient) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", c.Addr, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &redisConn{conn: conn, reader: bufio.NewReader(conn), timeout: c.Timeout}, nil
+}
+
+// Lists the string keys under prefix, with their values.
+func (conn *redisConn) list(prefix string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	cursor := "0"
+	for {
+		reply, err := conn.do("SCAN", cursor, "MATCH", redisPattern(prefix), "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("unexpected redis SCAN reply %v", reply)
+		}
+		cursor, _ = page[0].(string)
+		keys, _ := page[1].([]interface{})
+
+		if len(keys) > 0 {
+			args := []string{"MGET"}
+			for _, key := range keys {
+				key, _ := key.(string)
+				args = append(args, key)
+			}
+			reply, err := conn.do(args...)
+			if err != nil {
+				return nil, err
+			}
+			got, _ := reply.([]interface{})
+			for i, value := range got {
+				// MGET answers nil for keys that aren't strings
+				if value, ok := value.(string); ok && i+1 < len(args) {
+					values[args[i+1]] = value
+				}
+			}
+		}
+
+		if cursor == "0" || cursor == "" {
+			return values, nil
+		}
+	}
+}
+
+// Sends a command and reads its reply.
+func (conn *redisConn) do(args ...string) (interface{}, error) {
+	if conn.timeout > 0 {
+		conn.conn.SetDeadline(time.Now().Add(conn.timeout))
+	}
+
+	command := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		command += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(conn.conn, command); err != nil {
+		return nil, err
+	}
+	return conn.read()
+}
+
+// Reads a reply: a string, an int64, nil or a list of them. Error replies are
+// returned as errors.
+func (conn *redisConn) read() (interface{}, error) {
+	line, err := conn.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis answered %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis reply %q", line)
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		bulk := make([]byte, length+2)
+		if _, err := io.ReadFull(conn.reader, bulk); err != nil {
+			return nil, err
+		}
+		return string(bulk[:length]), nil
+	case '*':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis reply %q", line)
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, length)
+		for i := range items {
+			if items[i], err = conn.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("invalid redis reply %q", line)
+	}
+}
+
+func (conn *redisConn) Close() error {
+	return conn.conn.Close()
+}
+
+// The glob matching the keys under prefix, with its special characters
+// escaped.
+func redisPattern(prefix string) string {
+	prefix = cleanKey(prefix)
+	if prefix == "" {
+		return "*"
+	}
+
+	var escaped strings.Builder
+	for _, r := range prefix {
+		if strings.ContainsRune(`\*?[]`, r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String() + "/*"
+}
+
+// Turns the differences between two listings into etcd set and delete
+// responses, all at index.
+func redisChanges(before, after map[string]string, index uint64) []*etcd.Response {
+	var changes []*etcd.Response
+
+	for _, key := range sortedValueKeys(after) {
+		if old, ok := before[key]; ok && old == after[key] {
+			continue
+		}
+		changes = append(changes, &etcd.Response{Action: "set", Node: &etcd.Node{Key: "/" + cleanKey(key), Value: after[key], ModifiedIndex: index}})
+	}
+	for _, key := range sortedValueKeys(before) {
+		if _, ok := after[key]; ok {
+			continue
+		}
+		changes = append(changes, &etcd.Response{Action: "delete", Node: &etcd.Node{Key: "/" + cleanKey(key), ModifiedIndex: index}})
+	}
+
+	return changes
+}
+
+func sortedValueKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
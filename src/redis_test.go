@@ -0,0 +1,197 @@
+package src
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// A Redis server knowing just enough commands for the client, holding
+// values. Every subscriber gets the notifications sent to events.
+func redisServer(t *testing.T, values map[string]string, events chan string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		client := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+		for {
+			request, err := client.read()
+			if err != nil {
+				return
+			}
+			var args []string
+			for _, arg := range request.([]interface{}) {
+				args = append(args, arg.(string))
+			}
+
+			switch args[0] {
+			case "PING":
+				fmt.Fprint(conn, "+PONG\r\n")
+			case "SCAN":
+				assert.Equal(t, args[3], "rails/*")
+				var keys []string
+				for key := range values {
+					if strings.HasPrefix(key, "rails/") {
+						keys = append(keys, key)
+					}
+				}
+				fmt.Fprintf(conn, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+				for _, key := range keys {
+					fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(key), key)
+				}
+			case "MGET", "GET":
+				if args[0] == "MGET" {
+					fmt.Fprintf(conn, "*%d\r\n", len(args)-1)
+				}
+				for _, key := range args[1:] {
+					if value, ok := values[key]; ok {
+						fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+					} else {
+						fmt.Fprint(conn, "$-1\r\n")
+					}
+				}
+			case "PSUBSCRIBE":
+				assert.Equal(t, args[1], "__keyspace@0__:rails/*")
+				fmt.Fprintf(conn, "*3\r\n$10\r\npsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+				for event := range events {
+					parts := strings.SplitN(event, " ", 2)
+					channel := redisKeyspace + parts[1]
+					fmt.Fprintf(conn, "*4\r\n$8\r\npmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+						len(args[1]), args[1], len(channel), channel, len(parts[0]), parts[0])
+				}
+				return
+			default:
+				fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return listener
+}
+
+func TestRedisClient(t *testing.T) {
+	values := map[string]string{
+		"rails/database/host": "db01",
+		"rails/database/pool": "5",
+		"rails_other/secret":  "nope",
+	}
+	events := make(chan string, 10)
+	listener := redisServer(t, values, events)
+	defer listener.Close()
+
+	client := NewRedisClient(listener.Addr().String())
+	assert.Equal(t, client.SyncCluster(), true)
+
+	response, err := client.Get("/rails", false, true)
+	assert.Equal(t, err, nil)
+
+	env := Env{}
+	data := map[string]interface{}{}
+	env.BuildData(*response.Node, "/rails", data)
+	assert.Equal(t, data, map[string]interface{}{
+		"database": map[string]interface{}{"host": "db01", "pool": "5"},
+	})
+
+	// changed before the watch subscribed
+	values["rails/database/timeout"] = "10"
+
+	receiver := make(chan *etcd.Response)
+	stop := make(chan bool)
+	result := make(chan error)
+	go func() {
+		_, err := client.Watch("/rails", response.EtcdIndex+1, true, receiver, stop)
+		result <- err
+	}()
+
+	change := <-receiver
+	assert.Equal(t, change.Action+" "+change.Node.Key+" "+change.Node.Value, "set /rails/database/timeout 10")
+
+	values["rails/database/host"] = "db02"
+	delete(values, "rails/database/pool")
+	events <- "set rails/database/host"
+	events <- "del rails/database/pool"
+	events <- "hset rails/database/replica"
+	events <- "expired rails/database/unknown"
+	events <- "expired rails/database/timeout"
+
+	var changes []string
+	for i := 0; i < 3; i++ {
+		change := <-receiver
+		changes = append(changes, change.Action+" "+change.Node.Key+" "+change.Node.Value)
+	}
+	assert.Equal(t, changes, []string{
+		"set /rails/database/host db02",
+		"delete /rails/database/pool ",
+		"expire /rails/database/timeout ",
+	})
+
+	close(stop)
+	assert.Equal(t, <-result, etcd.ErrWatchStoppedByUser)
+	close(events)
+}
+
+func TestRedisClientWatcherStartsWarm(t *testing.T) {
+	values := map[string]string{"rails/database/host": "db01"}
+	events := make(chan string, 10)
+	listener := redisServer(t, values, events)
+	defer listener.Close()
+	defer close(events)
+
+	dir := "/rails"
+	renderer := &SignalingRenderer{Renders: make(chan string, 10)}
+	watcher := NewWatcher(NewRedisClient(listener.Addr().String()), &Env{EtcdDir: &dir, Renderer: renderer, Reloader: new(MockReloader)})
+	// a resync would wait this long first
+	watcher.MinBackoff = time.Hour
+	assert.Equal(t, watcher.Sync(), nil)
+	values["rails/database/host"] = "db02"
+
+	stop := make(chan bool)
+	result := make(chan error)
+	go func() {
+		result <- watcher.Run(stop)
+	}()
+
+	// the watch starts right away, with the change made since the Sync
+	select {
+	case <-renderer.Renders:
+	case <-time.After(time.Second):
+		t.Fatal("the watcher didn't start watching")
+	}
+	close(stop)
+	assert.Equal(t, <-result, nil)
+	assert.Equal(t, watcher.Env.Data["database"], map[string]interface{}{"host": "db02"})
+}
+
+func TestRedisPattern(t *testing.T) {
+	assert.Equal(t, redisPattern("/rails/"), "rails/*")
+	assert.Equal(t, redisPattern("app[1]*"), `app\[1\]\*/*`)
+	assert.Equal(t, redisPattern(""), "*")
+}
+
+func TestRedisClientRemembersEachPrefix(t *testing.T) {
+	client := NewRedisClient("127.0.0.1:6379")
+	db := map[string]string{"rails/db/host": "db01"}
+	secrets := map[string]string{"rails/secrets/key": "abc"}
+
+	assert.Equal(t, len(client.remember("/rails/db", db)), 0)
+	assert.Equal(t, len(client.remember("/rails/secrets", secrets)), 0)
+
+	// the watch on /rails/db doesn't see the keys of /rails/secrets gone
+	assert.Equal(t, client.remember("/rails/db/", db), db)
+}
@@ -1,9 +1,12 @@
 package src
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type Reloader interface {
-	Reload()
+	Reload(env Env) error
 	RegisterFlags()
 }
 
@@ -20,12 +23,32 @@ func RegisterReloader(name string, reloader Reloader) {
 	reloaders[name] = reloader
 }
 
+// Opens the reloader with the given name. A comma separated list of names
+// (like "touch,webhook") opens a reloader that runs each of them in order.
 func OpenReloader(reloaderName string) (Reloader, error) {
+	if strings.Contains(reloaderName, ",") {
+		var chain chainReloader
+		for _, name := range strings.Split(reloaderName, ",") {
+			reloader, err := OpenReloader(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, reloader)
+		}
+		return chain, nil
+	}
+
 	reloader, ok := reloaders[reloaderName]
 	if !ok {
 		return nil, fmt.Errorf("reloader: unkown driver %q (forgotten import?)", reloaderName)
 	}
 
+	if opener, ok := reloader.(Opener); ok {
+		if err := opener.Open(); err != nil {
+			return nil, fmt.Errorf("reloader: %s: %s", reloaderName, err)
+		}
+	}
+
 	return reloader, nil
 }
 
@@ -34,3 +57,25 @@ func RegisterReloaderFlags() {
 		reloader.RegisterFlags()
 	}
 }
+
+// Runs several reloaders in order. A failing reloader doesn't stop the next
+// ones, and all the failures are reported together.
+type chainReloader []Reloader
+
+func (chain chainReloader) Reload(env Env) error {
+	var failures []string
+
+	for _, reloader := range chain {
+		if err := reloader.Reload(env); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func (chain chainReloader) RegisterFlags() {
+}
@@ -0,0 +1,46 @@
+package src
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+type FailingReloader struct {
+	Called bool
+}
+
+func (r *FailingReloader) Reload(env Env) error {
+	r.Called = true
+	return errors.New("boom")
+}
+func (r *FailingReloader) RegisterFlags() {
+}
+
+func TestChainReloader(t *testing.T) {
+	failing, mock := new(FailingReloader), new(MockReloader)
+	reloader := chainReloader{failing, mock}
+
+	err := reloader.Reload(Env{})
+	assert.Equal(t, err.Error(), "boom")
+	assert.Equal(t, failing.Called, true)
+	assert.Equal(t, mock.Called, true)
+}
+
+func TestOpenReloaderChain(t *testing.T) {
+	failing, mock := new(FailingReloader), new(MockReloader)
+	RegisterReloader("test-failing", failing)
+	RegisterReloader("test-mock", mock)
+	t.Cleanup(func() {
+		delete(reloaders, "test-failing")
+		delete(reloaders, "test-mock")
+	})
+
+	reloader, err := OpenReloader("test-failing, test-mock")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, reloader, Reloader(chainReloader{failing, mock}))
+
+	_, err = OpenReloader("test-mock,bogus")
+	assert.NotEqual(t, err, nil)
+}
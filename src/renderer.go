@@ -5,10 +5,33 @@ import (
 )
 
 type Renderer interface {
-	Render(env Env)
+	// Serializes the configuration. Cycle writes it to the file.
+	Render(env Env) ([]byte, error)
+	// The file the configuration is written to, unless Output is set
+	File() string
 	RegisterFlags()
 }
 
+// Opener is implemented by drivers that need to validate their flags or
+// load resources before they are used. OpenRenderer calls Open on the
+// selected renderer, so a bad configuration fails at startup.
+type Opener interface {
+	Open() error
+}
+
+// CommentedRenderer is implemented by renderers whose format has # comments,
+// so their files can start with the Header.
+type CommentedRenderer interface {
+	Commented() bool
+}
+
+// MultiRenderer is implemented by renderers that can write several files.
+// Cycle writes each of the files RenderFiles returns, by path, instead of
+// calling Render.
+type MultiRenderer interface {
+	RenderFiles(env Env) (map[string][]byte, error)
+}
+
 var renderers = make(map[string]Renderer)
 
 func RegisterRenderer(name string, renderer Renderer) {
@@ -27,6 +50,12 @@ func OpenRenderer(rendererName string) (Renderer, error) {
 		return nil, fmt.Errorf("renderer: unkown driver %q (forgotten import?)", rendererName)
 	}
 
+	if opener, ok := renderer.(Opener); ok {
+		if err := opener.Open(); err != nil {
+			return nil, fmt.Errorf("renderer: %s: %s", rendererName, err)
+		}
+	}
+
 	return renderer, nil
 }
 
@@ -0,0 +1,157 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type RubyRenderer struct {
+	RubyFile   *string
+	SymbolKeys *bool
+}
+
+// Renders the data as a frozen Ruby hash literal, to be eval'd by the app.
+// Strings are double quoted with anything Ruby would interpret escaped, while
+// numbers, booleans and nil (from -coerce-types) are written as such. Keys are
+// strings, or symbols with -ruby-symbol-keys, and sorted to keep the file
+// stable between cycles.
+func (renderer *RubyRenderer) Render(env Env) ([]byte, error) {
+	path := env.outputPath(renderer.File())
+	env.Logger.Infof("[RUBY RENDERER] Rendering to %s", path)
+
+	var out bytes.Buffer
+	renderer.write(&out, env.Data, "")
+	out.WriteString("\n")
+
+	return out.Bytes(), nil
+}
+
+func (renderer *RubyRenderer) write(out *bytes.Buffer, value interface{}, indent string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			out.WriteString("{}.freeze")
+			return
+		}
+
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		out.WriteString("{\n")
+		for _, key := range keys {
+			out.WriteString(indent + "  " + renderer.key(key) + " => ")
+			renderer.write(out, value[key], indent+"  ")
+			out.WriteString(",\n")
+		}
+		out.WriteString(indent + "}.freeze")
+	case []interface{}:
+		if len(value) == 0 {
+			out.WriteString("[].freeze")
+			return
+		}
+
+		out.WriteString("[\n")
+		for _, child := range value {
+			out.WriteString(indent + "  ")
+			renderer.write(out, child, indent+"  ")
+			out.WriteString(",\n")
+		}
+		out.WriteString(indent + "].freeze")
+	case nil:
+		out.WriteString("nil")
+	case bool:
+		out.WriteString(strconv.FormatBool(value))
+	case int, int64:
+		fmt.Fprint(out, value)
+	case float64:
+		out.WriteString(rubyFloat(value))
+	case json.Number:
+		out.WriteString(value.String())
+	case string:
+		out.WriteString(rubyQuote(value))
+	default:
+		out.WriteString(rubyQuote(fmt.Sprint(value)))
+	}
+}
+
+var rubySymbol = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*[?!]?$`)
+
+func (renderer *RubyRenderer) key(key string) string {
+	if !*renderer.SymbolKeys {
+		return rubyQuote(key)
+	}
+	if rubySymbol.MatchString(key) {
+		return ":" + key
+	}
+	return ":" + rubyQuote(key)
+}
+
+// Keeps floats floats in Ruby, where 5 would be an Integer. NaN and the
+// infinities have no Ruby literal, so they're quoted.
+func rubyFloat(value float64) string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return rubyQuote(strconv.FormatFloat(value, 'g', -1, 64))
+	}
+	out := strconv.FormatFloat(value, 'g', -1, 64)
+	if !strings.ContainsAny(out, ".eN") {
+		out += ".0"
+	}
+	return out
+}
+
+// Double quotes a Ruby string. Besides quotes and backslashes, # is escaped so
+// values can't interpolate code with #{...}, and control characters are
+// written as escapes.
+func rubyQuote(value string) string {
+	var out bytes.Buffer
+	out.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"', '\\', '#':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&out, `\x%02X`, r)
+			} else {
+				out.WriteRune(r)
+			}
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
+
+func (renderer *RubyRenderer) File() string {
+	return *renderer.RubyFile
+}
+
+func (renderer *RubyRenderer) Commented() bool {
+	return true
+}
+
+func (renderer *RubyRenderer) RegisterFlags() {
+	renderer.RubyFile = flag.String("ruby-file", "config/config.rb", "The output of the Ruby file")
+	renderer.SymbolKeys = flag.Bool("ruby-symbol-keys", false, "Use symbols instead of strings as the keys of the Ruby hash")
+}
+
+func init() {
+	rubyRenderer := RubyRenderer{}
+	RegisterRenderer("ruby", &rubyRenderer)
+}
@@ -0,0 +1,89 @@
+package src
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestRubyRender(t *testing.T) {
+	file := "config.rb"
+	symbolKeys := false
+	renderer := RubyRenderer{RubyFile: &file, SymbolKeys: &symbolKeys}
+
+	data := map[string]interface{}{
+		"database": map[string]interface{}{
+			"pool":    int64(5),
+			"timeout": 2.0,
+			"ssl":     true,
+			"host":    "localhost",
+		},
+		"servers": []interface{}{"web01", "web02"},
+		"empty":   map[string]interface{}{},
+	}
+	out, err := renderer.Render(Env{Data: data})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `{
+  "database" => {
+    "host" => "localhost",
+    "pool" => 5,
+    "ssl" => true,
+    "timeout" => 2.0,
+  }.freeze,
+  "empty" => {}.freeze,
+  "servers" => [
+    "web01",
+    "web02",
+  ].freeze,
+}.freeze
+`)
+}
+
+func TestRubyRenderSymbolKeys(t *testing.T) {
+	file := "config.rb"
+	symbolKeys := true
+	renderer := RubyRenderer{RubyFile: &file, SymbolKeys: &symbolKeys}
+
+	out, err := renderer.Render(Env{Data: map[string]interface{}{"pool": "5", "api-key": "abc", "ready?": "yes"}})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `{
+  :"api-key" => "abc",
+  :pool => "5",
+  :ready? => "yes",
+}.freeze
+`)
+}
+
+func TestRubyRenderExpandJson(t *testing.T) {
+	file := "config.rb"
+	symbolKeys := true
+	renderer := RubyRenderer{RubyFile: &file, SymbolKeys: &symbolKeys}
+
+	env := Env{ExpandJson: true, Data: map[string]interface{}{}}
+	env.UpdateData([]string{"database"}, `{"pool": 5, "timeout": 2.5}`, "set", env.Data)
+	out, err := renderer.Render(env)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `{
+  :database => {
+    :pool => 5,
+    :timeout => 2.5,
+  }.freeze,
+}.freeze
+`)
+}
+
+func TestRubyFloat(t *testing.T) {
+	assert.Equal(t, rubyFloat(2), "2.0")
+	assert.Equal(t, rubyFloat(1e21), "1e+21")
+	assert.Equal(t, rubyFloat(math.NaN()), `"NaN"`)
+	assert.Equal(t, rubyFloat(math.Inf(-1)), `"-Inf"`)
+}
+
+func TestRubyQuote(t *testing.T) {
+	assert.Equal(t, rubyQuote(`say "hi"`), `"say \"hi\""`)
+	assert.Equal(t, rubyQuote("line one\nline two\ttabbed"), `"line one\nline two\ttabbed"`)
+	assert.Equal(t, rubyQuote(`C:\rails #{exit}`), `"C:\\rails \#{exit}"`)
+	assert.Equal(t, rubyQuote("bell\a"), `"bell\x07"`)
+	assert.Equal(t, rubyQuote("café"), `"café"`)
+}
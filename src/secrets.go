@@ -0,0 +1,114 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Keys whose values are masked in the logs when -secret-keys isn't given
+var DefaultSecretKeys = []string{"*password*", "*passwd*", "*secret*", "*token*", "*api_key*", "*apikey*", "*private_key*", "*credential*"}
+
+// What secret values are replaced with in the logs
+const secretMask = "***"
+
+// Reports whether the key made of parts holds a secret. SecretKeys are
+// matched case insensitively: globs against the whole key or any of its
+// parts, anything else as a substring of the key.
+func (env *Env) secret(parts []string) bool {
+	key := strings.ToLower(strings.Join(parts, "/"))
+
+	for _, pattern := range env.SecretKeys {
+		pattern = strings.ToLower(pattern)
+		if !strings.ContainsAny(pattern, "*?[") {
+			if strings.Contains(key, pattern) {
+				return true
+			}
+			continue
+		}
+
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+		for _, part := range parts {
+			if ok, _ := path.Match(pattern, strings.ToLower(part)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the value to log for the key made of parts.
+func (env *Env) maskValue(parts []string, value string) string {
+	if value != "" && env.secret(parts) {
+		return secretMask
+	}
+	return value
+}
+
+// The secret values of the data, as they can show up in a rendered file:
+// everything under a key matching SecretKeys, the lines of multi-line values
+// apart, and JSON and XML escaped too.
+func (env *Env) secretValues() []string {
+	var values []string
+	var collect func(parts []string, value interface{}, secret bool)
+	collect = func(parts []string, value interface{}, secret bool) {
+		secret = secret || len(parts) > 0 && env.secret(parts)
+		switch value := value.(type) {
+		case map[string]interface{}:
+			for key, child := range value {
+				collect(append(parts[:len(parts):len(parts)], key), child, secret)
+			}
+		case []interface{}:
+			for _, child := range value {
+				collect(parts, child, secret)
+			}
+		case nil:
+		default:
+			if !secret {
+				return
+			}
+			for _, line := range strings.Split(fmt.Sprint(value), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					values = append(values, line, jsonEscaped(line), xmlEscaped(line))
+				}
+			}
+		}
+	}
+	collect(nil, env.Data, false)
+	return values
+}
+
+func jsonEscaped(value string) string {
+	quoted, _ := json.Marshal(value)
+	return string(quoted[1 : len(quoted)-1])
+}
+
+func xmlEscaped(value string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
+
+// Returns a function hiding secrets in the lines of a rendered file: the
+// given values (longest first, so one holding another is hidden whole) and
+// the secrets read from Vault.
+func (env *Env) lineMasker(secrets []string) func(string) string {
+	secrets = append([]string(nil), secrets...)
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+
+	return func(line string) string {
+		if env.Vault != nil {
+			line = env.Vault.mask(line)
+		}
+		for _, secret := range secrets {
+			line = strings.Replace(line, secret, secretMask, -1)
+		}
+		return line
+	}
+}
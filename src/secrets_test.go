@@ -0,0 +1,60 @@
+package src
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSecret(t *testing.T) {
+	env := &Env{SecretKeys: []string{"*password*", "api/*", "cert"}}
+
+	assert.Equal(t, env.secret([]string{"database", "password"}), true)
+	assert.Equal(t, env.secret([]string{"database", "DB_PASSWORD"}), true)
+	assert.Equal(t, env.secret([]string{"api", "key"}), true)
+	assert.Equal(t, env.secret([]string{"ssl", "client_cert"}), true)
+	assert.Equal(t, env.secret([]string{"database", "host"}), false)
+	assert.Equal(t, env.secret([]string{"api"}), false)
+
+	assert.Equal(t, env.maskValue([]string{"database", "password"}, "hunter2"), "***")
+	assert.Equal(t, env.maskValue([]string{"database", "host"}, "db01"), "db01")
+	assert.Equal(t, (&Env{}).maskValue([]string{"database", "password"}, "hunter2"), "hunter2")
+}
+
+func TestLineMasker(t *testing.T) {
+	env := &Env{SecretKeys: DefaultSecretKeys, Data: map[string]interface{}{
+		"database": map[string]interface{}{"host": "db01", "password": "hunter&2"},
+		"api_tokens": []interface{}{"tok1", "tok2"},
+		"secrets":    map[string]interface{}{"key_base": "abc123"},
+		"private_key": "-----BEGIN KEY-----\nMIIEow\n-----END KEY-----",
+		"pool":        int64(5),
+	}}
+	mask := env.lineMasker(env.secretValues())
+
+	file, root, symbolKeys := "config", "settings", false
+	renderers := []Renderer{
+		&XmlRenderer{XmlFile: &file, Root: &root},
+		&RubyRenderer{RubyFile: &file, SymbolKeys: &symbolKeys},
+		&YamlRenderer{YamlFile: &file},
+	}
+	for _, renderer := range renderers {
+		out, err := renderer.Render(*env)
+		assert.Equal(t, err, nil)
+
+		var masked []string
+		for _, line := range strings.Split(string(out), "\n") {
+			masked = append(masked, mask(line))
+		}
+		for _, secret := range []string{"hunter", "tok1", "tok2", "abc123", "MIIEow"} {
+			assert.Equal(t, strings.Contains(strings.Join(masked, "\n"), secret), false)
+		}
+		assert.T(t, strings.Contains(strings.Join(masked, "\n"), "db01"))
+	}
+
+	assert.Equal(t, mask(`  <password>hunter&amp;2</password>`), "  <password>***</password>")
+	assert.Equal(t, mask(`    "password" => "hunter&2",`), `    "password" => "***",`)
+	assert.Equal(t, mask("- tok1"), "- ***")
+	assert.Equal(t, mask("  key_base: abc123"), "  key_base: ***")
+	assert.Equal(t, mask("  pool: 5"), "  pool: 5")
+}
@@ -0,0 +1,69 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Returns the data to render to path. With RailsEnv the data only fills the
+// top-level section of that name: the other sections are read back from the
+// file with parse, so they can be maintained by hand. A file that doesn't
+// parse as format is an error, rather than losing those sections. With
+// WrapEnv the data is already wrapped in its section, alone.
+func (env *Env) sectionData(path string, format string, parse func([]byte) (interface{}, error)) (map[string]interface{}, error) {
+	if env.RailsEnv == "" || env.WrapEnv {
+		return env.Data, nil
+	}
+
+	data := make(map[string]interface{})
+	in, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(in)) > 0 {
+		parsed, err := parse(in)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fill the %s section of %s, it isn't valid %s: %s", env.RailsEnv, path, format, err)
+		}
+		sections, ok := parsed.(map[string]interface{})
+		if !ok && parsed != nil {
+			return nil, fmt.Errorf("cannot fill the %s section of %s, it should hold a map of sections", env.RailsEnv, path)
+		}
+		for key, section := range sections {
+			data[key] = section
+		}
+	}
+
+	section := env.Data
+	if section == nil {
+		section = map[string]interface{}{}
+	}
+	data[env.RailsEnv] = section
+	return data, nil
+}
+
+func parseYamlSections(in []byte) (interface{}, error) {
+	var parsed interface{}
+	err := yaml.Unmarshal(in, &parsed)
+	return stringKeys(parsed), err
+}
+
+func parseJsonSections(in []byte) (interface{}, error) {
+	var parsed interface{}
+	decoder := json.NewDecoder(bytes.NewReader(in))
+	decoder.UseNumber()
+	err := decoder.Decode(&parsed)
+	return parsed, err
+}
+
+func parseTomlSections(in []byte) (interface{}, error) {
+	var parsed map[string]interface{}
+	_, err := toml.Decode(string(in), &parsed)
+	return parsed, err
+}
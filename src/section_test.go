@@ -0,0 +1,79 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSectionRender(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "database.json")
+	renderer := JsonRenderer{JsonFile: &file}
+	env := Env{RailsEnv: "production", Data: map[string]interface{}{"pool": "10"}, Renderer: &renderer}
+
+	// a missing file gets just the section
+	_, err := env.render()
+	assert.Equal(t, err, nil)
+	out, _ := ioutil.ReadFile(file)
+	assert.Equal(t, string(out), "{\n  \"production\": {\n    \"pool\": \"10\"\n  }\n}\n")
+
+	// the other sections are kept as they are
+	ioutil.WriteFile(file, []byte(`{"development": {"pool": 5}, "production": {"pool": 1, "host": "old"}}`), 0644)
+	_, err = env.render()
+	assert.Equal(t, err, nil)
+	out, _ = ioutil.ReadFile(file)
+	assert.Equal(t, string(out), `{
+  "development": {
+    "pool": 5
+  },
+  "production": {
+    "pool": "10"
+  }
+}
+`)
+}
+
+func TestSectionRenderInvalidFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "database.yml")
+	renderer := YamlRenderer{YamlFile: &file}
+	env := Env{RailsEnv: "production", Data: map[string]interface{}{"pool": "10"}, Renderer: &renderer}
+
+	broken := "development:\n\tpool: 5\n"
+	ioutil.WriteFile(file, []byte(broken), 0644)
+	_, err := env.render()
+	assert.T(t, strings.HasPrefix(err.Error(), "cannot fill the production section of "+file+", it isn't valid YAML: "))
+
+	// the file is left alone
+	out, _ := ioutil.ReadFile(file)
+	assert.Equal(t, string(out), broken)
+
+	ioutil.WriteFile(file, []byte(`["development", "production"]`), 0644)
+	_, err = env.render()
+	assert.Equal(t, err.Error(), "cannot fill the production section of "+file+", it should hold a map of sections")
+}
+
+func TestWrapEnv(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "database.json")
+	renderer := JsonRenderer{JsonFile: &file}
+	env := Env{RailsEnv: "production", WrapEnv: true, NoReload: true, Data: map[string]interface{}{"pool": "10"}, Renderer: &renderer}
+
+	// the other sections are replaced
+	ioutil.WriteFile(file, []byte(`{"development": {"pool": 5}}`), 0644)
+	assert.Equal(t, env.Cycle(), nil)
+	out, _ := ioutil.ReadFile(file)
+	assert.Equal(t, string(out), "{\n  \"production\": {\n    \"pool\": \"10\"\n  }\n}\n")
+	assert.Equal(t, env.Data, map[string]interface{}{"pool": "10"})
+}
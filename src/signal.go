@@ -0,0 +1,102 @@
+package src
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var signals = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"TERM":  syscall.SIGTERM,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+	"TTIN":  syscall.SIGTTIN,
+	"TTOU":  syscall.SIGTTOU,
+}
+
+type SignalReloader struct {
+	Pid     *int
+	PidFile *string
+	Signal  *string
+
+	signal syscall.Signal
+}
+
+// Sends the signal to the process given by -reload-pid or -reload-pidfile.
+// The pidfile is read on every reload, so restarts of the process are fine.
+func (reloader *SignalReloader) Reload(env Env) error {
+	pid := *reloader.Pid
+	if *reloader.PidFile != "" {
+		var err error
+		if pid, err = readPidFile(*reloader.PidFile); err != nil {
+			return err
+		}
+	}
+
+	env.Logger.Infof("[SIGNAL RELOADER] Sending %s to %d", *reloader.Signal, pid)
+
+	return signalProcess(pid, reloader.signal)
+}
+
+func (reloader *SignalReloader) RegisterFlags() {
+	reloader.Pid = flag.Int("reload-pid", 0, "The pid of the process to signal when we need to reload")
+	reloader.PidFile = flag.String("reload-pidfile", "", "The pidfile of the process to signal when we need to reload")
+	reloader.Signal = flag.String("reload-signal", "HUP", "The signal to send when we need to reload")
+}
+
+func (reloader *SignalReloader) Open() error {
+	sig, err := parseSignal(*reloader.Signal)
+	if err != nil {
+		return err
+	}
+	reloader.signal = sig
+
+	if *reloader.Pid == 0 && *reloader.PidFile == "" {
+		return fmt.Errorf("-reload-pid or -reload-pidfile is required")
+	}
+	return nil
+}
+
+// Parses a signal name like HUP or SIGHUP.
+func parseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
+
+// Reads the pid stored in a pidfile.
+func readPidFile(path string) (int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid in %s", path)
+	}
+	return pid, nil
+}
+
+func signalProcess(pid int, sig os.Signal) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(sig)
+}
+
+func init() {
+	signalReloader := SignalReloader{}
+	RegisterReloader("signal", &signalReloader)
+}
@@ -0,0 +1,40 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestParseSignal(t *testing.T) {
+	for _, name := range []string{"HUP", "hup", "SIGHUP"} {
+		sig, err := parseSignal(name)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, sig, syscall.SIGHUP)
+	}
+
+	_, err := parseSignal("BOGUS")
+	assert.NotEqual(t, err, nil)
+}
+
+func TestReadPidFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "app.pid")
+	ioutil.WriteFile(file, []byte("1234\n"), 0644)
+	pid, err := readPidFile(file)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pid, 1234)
+
+	ioutil.WriteFile(file, []byte("garbage"), 0644)
+	_, err = readPidFile(file)
+	assert.NotEqual(t, err, nil)
+
+	_, err = readPidFile(filepath.Join(dir, "missing.pid"))
+	assert.NotEqual(t, err, nil)
+}
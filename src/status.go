@@ -0,0 +1,202 @@
+package src
+
+import (
+	"sync"
+	"time"
+)
+
+// Status records the state of the daemon (etcd connection, last render and
+// reload) so it can be reported while the process keeps running. All methods
+// are safe to call on a nil Status.
+type Status struct {
+	mutex      sync.Mutex
+	connected  bool
+	ready      bool
+	renderTime time.Time
+	renderErr  error
+	reloadTime time.Time
+	reloadErr  error
+	invalid    error
+	// When the last render and reload succeeded
+	renderOK time.Time
+	reloadOK time.Time
+	events   int
+	// Renders given up on past the -render-timeout
+	renderTimeouts int
+}
+
+// What /healthz reports, for whoever has to debug the daemon. Times are those
+// of the last successful render and reload, errors those of the last attempt
+// if it failed.
+type StatusReport struct {
+	Status          string     `json:"status"`
+	Reason          string     `json:"reason,omitempty"`
+	EtcdConnected   bool       `json:"etcdConnected"`
+	LastRenderTime  *time.Time `json:"lastRenderTime"`
+	LastRenderError *string    `json:"lastRenderError"`
+	LastReloadTime  *time.Time `json:"lastReloadTime"`
+	LastReloadError *string    `json:"lastReloadError"`
+	EventCount      int        `json:"eventCount"`
+	RenderTimeouts  int        `json:"renderTimeouts"`
+}
+
+// Records whether the etcd watch is connected.
+func (status *Status) SetConnected(connected bool) {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	status.connected = connected
+}
+
+// Marks the initial render as done.
+func (status *Status) SetReady() {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	status.ready = true
+}
+
+// Records the outcome of a render.
+func (status *Status) SetRender(err error) {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	status.renderTime = time.Now()
+	status.renderErr = err
+	if err == nil {
+		status.renderOK = status.renderTime
+	}
+	if _, timeout := err.(renderTimeoutError); timeout {
+		status.renderTimeouts++
+	}
+}
+
+// Records the outcome of a reload.
+func (status *Status) SetReload(err error) {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	status.reloadTime = time.Now()
+	status.reloadErr = err
+	if err == nil {
+		status.reloadOK = status.reloadTime
+	}
+}
+
+// Counts an etcd event.
+func (status *Status) AddEvent() {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	status.events++
+}
+
+// Records whether the last rendered configuration parsed back.
+func (status *Status) SetValidation(err error) {
+	if status == nil {
+		return
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	status.invalid = err
+}
+
+// Returns why the last rendered configuration was rejected, if it was.
+func (status *Status) LastValidation() error {
+	if status == nil {
+		return nil
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	return status.invalid
+}
+
+// Returns when the last reload happened and how it failed, if it did.
+func (status *Status) LastReload() (time.Time, error) {
+	if status == nil {
+		return time.Time{}, nil
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	return status.reloadTime, status.reloadErr
+}
+
+// Healthy while the etcd watch is connected and the last render succeeded.
+func (status *Status) Healthy() bool {
+	if status == nil {
+		return false
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	return status.connected && status.renderErr == nil
+}
+
+// Ready once the initial render is done.
+func (status *Status) Ready() bool {
+	if status == nil {
+		return false
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	return status.ready
+}
+
+// Describes the state of the daemon, as healthy or not.
+func (status *Status) Report(healthy bool) StatusReport {
+	report := StatusReport{Status: "ok"}
+	if !healthy {
+		report.Status = "unavailable"
+	}
+	if status == nil {
+		return report
+	}
+
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	report.EtcdConnected = status.connected
+	report.LastRenderTime = reportTime(status.renderOK)
+	report.LastRenderError = reportError(status.renderErr)
+	report.LastReloadTime = reportTime(status.reloadOK)
+	report.LastReloadError = reportError(status.reloadErr)
+	report.EventCount = status.events
+	report.RenderTimeouts = status.renderTimeouts
+	if status.invalid != nil {
+		report.Reason = status.invalid.Error()
+	}
+	return report
+}
+
+func reportTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func reportError(err error) *string {
+	if err == nil {
+		return nil
+	}
+	message := err.Error()
+	return &message
+}
@@ -0,0 +1,174 @@
+package src
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Statuses of the supervisord XML-RPC API
+const (
+	supervisorSuccess        = 80
+	supervisorNotRunning     = 70
+	supervisorAlreadyStarted = 60
+)
+
+type SupervisorReloader struct {
+	Group   *string
+	Command *string
+	Url     *string
+}
+
+// Restarts the -supervisor-group program group of supervisord. By default it
+// runs supervisorctl restart (with its output in the log), failing on a
+// nonzero exit status. With -supervisor-url the group is stopped and started
+// again through the XML-RPC API instead. Either way, taking longer than
+// -reload-timeout fails the reload, and failures are retried as
+// -reload-retries says.
+func (reloader *SupervisorReloader) Reload(env Env) error {
+	if *reloader.Url == "" {
+		command := *reloader.Command + " restart " + shellQuote(*reloader.Group+":*")
+		return runCommand(env, "SUPERVISOR RELOADER", command, env.ReloadTimeout, env.drainTimeout())
+	}
+
+	env.Logger.Infof("[SUPERVISOR RELOADER] Restarting group %s through %s", *reloader.Group, *reloader.Url)
+	if err := reloader.call("supervisor.stopProcessGroup", supervisorNotRunning, env.ReloadTimeout); err != nil {
+		return fmt.Errorf("cannot stop group %s: %s", *reloader.Group, err)
+	}
+	if err := reloader.call("supervisor.startProcessGroup", supervisorAlreadyStarted, env.ReloadTimeout); err != nil {
+		return fmt.Errorf("cannot start group %s: %s", *reloader.Group, err)
+	}
+	return nil
+}
+
+// Calls an XML-RPC method acting on the group, failing on a fault, after
+// timeout (unless it's 0) or when a process of the group has a status other
+// than success or tolerated.
+func (reloader *SupervisorReloader) call(method string, tolerated int, timeout time.Duration) error {
+	client, endpoint, err := reloader.client(timeout)
+	if err != nil {
+		return err
+	}
+
+	var request bytes.Buffer
+	request.WriteString(`<?xml version="1.0"?><methodCall><methodName>` + method + `</methodName><params><param><value><string>`)
+	xml.EscapeText(&request, []byte(*reloader.Group))
+	request.WriteString(`</string></value></param></params></methodCall>`)
+
+	response, err := client.Post(endpoint, "text/xml", &request)
+	if err != nil {
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+		return err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("supervisord answered %s", response.Status)
+	}
+
+	var result struct {
+		Fault  *xmlrpcValue  `xml:"fault>value"`
+		Params []xmlrpcValue `xml:"params>param>value"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("invalid XML-RPC response: %s", err)
+	}
+	if result.Fault != nil {
+		return fmt.Errorf("%s", result.Fault.member("faultString").String)
+	}
+
+	// one struct per process of the group
+	var failures []string
+	for _, param := range result.Params {
+		for _, process := range param.Array {
+			status := process.member("status").Int
+			if status != supervisorSuccess && status != tolerated {
+				failures = append(failures, process.member("name").String+": "+process.member("description").String)
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// The HTTP client talking to supervisord, giving up after timeout (unless
+// it's 0), along with the URL of its XML-RPC endpoint.
+func (reloader *SupervisorReloader) client(timeout time.Duration) (*http.Client, string, error) {
+	client := &http.Client{Timeout: timeout}
+
+	address, err := url.Parse(*reloader.Url)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch address.Scheme {
+	case "http", "https":
+		return client, *reloader.Url, nil
+	case "unix":
+		client.Transport = &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", address.Path)
+			},
+		}
+		return client, "http://supervisor/RPC2", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported supervisor URL scheme %q", address.Scheme)
+	}
+}
+
+func (reloader *SupervisorReloader) RegisterFlags() {
+	reloader.Group = flag.String("supervisor-group", "", "The supervisord program group the supervisor reloader restarts")
+	reloader.Command = flag.String("supervisorctl", "supervisorctl", "The supervisorctl command, with any options like -c")
+	reloader.Url = flag.String("supervisor-url", "", "Restart through the supervisord XML-RPC API at this URL (http://host:9001/RPC2 or unix:///path.sock) instead of supervisorctl")
+}
+
+func (reloader *SupervisorReloader) Open() error {
+	if *reloader.Group == "" {
+		return fmt.Errorf("-supervisor-group is required")
+	}
+	return nil
+}
+
+// An XML-RPC value, only as much of it as supervisord answers.
+type xmlrpcValue struct {
+	String string         `xml:"string"`
+	Int    int            `xml:"int"`
+	Struct []xmlrpcMember `xml:"struct>member"`
+	Array  []xmlrpcValue  `xml:"array>data>value"`
+}
+
+type xmlrpcMember struct {
+	Name  string      `xml:"name"`
+	Value xmlrpcValue `xml:"value"`
+}
+
+// The member of a struct value with the given name, or a zero value.
+func (value xmlrpcValue) member(name string) xmlrpcValue {
+	for _, member := range value.Struct {
+		if member.Name == name {
+			return member.Value
+		}
+	}
+	return xmlrpcValue{}
+}
+
+// Single quotes value for the shell.
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+func init() {
+	supervisorReloader := SupervisorReloader{}
+	RegisterReloader("supervisor", &supervisorReloader)
+}
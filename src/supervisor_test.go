@@ -0,0 +1,95 @@
+package src
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func supervisorStatus(name string, status int, description string) string {
+	return `<value><struct>
+<member><name>name</name><value><string>` + name + `</string></value></member>
+<member><name>status</name><value><int>` + strconv.Itoa(status) + `</int></value></member>
+<member><name>description</name><value><string>` + description + `</string></value></member>
+</struct></value>`
+}
+
+func TestSupervisorReloadXmlRpc(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.T(t, strings.Contains(string(body), "<string>web</string>"))
+
+		method := strings.Split(strings.Split(string(body), "<methodName>")[1], "</methodName>")[0]
+		calls = append(calls, method)
+
+		// web_1 was already stopped
+		results := supervisorStatus("web_0", 80, "OK") + supervisorStatus("web_1", 70, "NOT_RUNNING")
+		if method == "supervisor.startProcessGroup" {
+			results = supervisorStatus("web_0", 80, "OK") + supervisorStatus("web_1", 80, "OK")
+			if len(calls) > 2 {
+				results = supervisorStatus("web_0", 50, "SPAWN_ERROR")
+			}
+		}
+		w.Write([]byte(`<?xml version="1.0"?><methodResponse><params><param><value><array><data>` + results + `</data></array></value></param></params></methodResponse>`))
+	}))
+	defer server.Close()
+
+	group, command, url := "web", "supervisorctl", server.URL+"/RPC2"
+	reloader := SupervisorReloader{Group: &group, Command: &command, Url: &url}
+	assert.Equal(t, reloader.Reload(Env{}), nil)
+	assert.Equal(t, calls, []string{"supervisor.stopProcessGroup", "supervisor.startProcessGroup"})
+
+	assert.Equal(t, reloader.Reload(Env{}).Error(), "cannot start group web: web_0: SPAWN_ERROR")
+}
+
+func TestSupervisorReloadFault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0"?><methodResponse><fault><value><struct>
+<member><name>faultCode</name><value><int>10</int></value></member>
+<member><name>faultString</name><value><string>BAD_NAME: web</string></value></member>
+</struct></value></fault></methodResponse>`))
+	}))
+	defer server.Close()
+
+	group, command, url := "web", "supervisorctl", server.URL+"/RPC2"
+	reloader := SupervisorReloader{Group: &group, Command: &command, Url: &url}
+	assert.Equal(t, reloader.Reload(Env{}).Error(), "cannot stop group web: BAD_NAME: web")
+}
+
+func TestSupervisorReloadCommand(t *testing.T) {
+	group, command, url := "web", "echo", ""
+	reloader := SupervisorReloader{Group: &group, Command: &command, Url: &url}
+	assert.Equal(t, reloader.Reload(Env{}), nil)
+
+	command = "false"
+	assert.NotEqual(t, reloader.Reload(Env{}), nil)
+}
+
+func TestSupervisorReloadTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	env := Env{ReloadTimeout: 50 * time.Millisecond, DrainTimeout: 50 * time.Millisecond}
+	group, command, url := "web", "supervisorctl", server.URL+"/RPC2"
+	reloader := SupervisorReloader{Group: &group, Command: &command, Url: &url}
+	assert.Equal(t, reloader.Reload(env).Error(), "cannot stop group web: timed out after 50ms")
+
+	command, url = "sleep 5 &&", ""
+	err := reloader.Reload(env)
+	assert.NotEqual(t, err, nil)
+	assert.T(t, strings.HasSuffix(err.Error(), "timed out after 50ms"))
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, shellQuote("web:*"), `'web:*'`)
+	assert.Equal(t, shellQuote("it's"), `'it'\''s'`)
+}
@@ -0,0 +1,111 @@
+package src
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sends state (like READY=1) to systemd through the socket in NOTIFY_SOCKET,
+// as sd_notify does. Reports whether it was sent: when not running under a
+// unit of Type=notify there's no socket, and nothing to do.
+func SdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	if socket[0] == '@' {
+		// an abstract socket
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// How often systemd expects a WATCHDOG=1, from WATCHDOG_USEC, or 0 when the
+// watchdog isn't enabled for this process.
+func SdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Heartbeat records when a loop last made progress, so the watchdog can tell
+// a daemon that's waiting for changes from one that's stuck.
+type Heartbeat struct {
+	last  time.Time
+	mutex sync.Mutex
+}
+
+func NewHeartbeat() *Heartbeat {
+	return &Heartbeat{last: time.Now()}
+}
+
+// Records progress made now.
+func (heartbeat *Heartbeat) Beat() {
+	if heartbeat == nil {
+		return
+	}
+	heartbeat.mutex.Lock()
+	defer heartbeat.mutex.Unlock()
+	heartbeat.last = time.Now()
+}
+
+// How long ago progress was last made.
+func (heartbeat *Heartbeat) Since() time.Duration {
+	heartbeat.mutex.Lock()
+	defer heartbeat.mutex.Unlock()
+	return time.Since(heartbeat.last)
+}
+
+// Sends WATCHDOG=1 twice per interval until stop is closed, as long as every
+// heartbeat made progress within the interval, so systemd restarts the daemon
+// once one of its loops gets stuck.
+func SdWatchdog(interval time.Duration, heartbeats []*Heartbeat, logger *Logger, stop chan bool) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if stuck := stuckFor(heartbeats, interval); stuck > 0 {
+				logger.Warnf("[SYSTEMD] No progress for %s, not pinging the watchdog", stuck)
+				continue
+			}
+			if _, err := SdNotify("WATCHDOG=1"); err != nil {
+				logger.Warnf("[SYSTEMD] Cannot ping the watchdog: %s", err)
+			}
+		}
+	}
+}
+
+// How long the longest stuck heartbeat went without progress, or 0 when they
+// all made progress within interval.
+func stuckFor(heartbeats []*Heartbeat, interval time.Duration) time.Duration {
+	var stuck time.Duration
+	for _, heartbeat := range heartbeats {
+		if since := heartbeat.Since(); since > interval && since > stuck {
+			stuck = since
+		}
+	}
+	return stuck
+}
@@ -0,0 +1,87 @@
+package src
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSdNotify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	sent, err := SdNotify("READY=1")
+	assert.Equal(t, sent, false)
+	assert.Equal(t, err, nil)
+
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.Equal(t, err, nil)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	sent, err = SdNotify("READY=1")
+	assert.Equal(t, sent, true)
+	assert.Equal(t, err, nil)
+
+	buffer := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buffer)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(buffer[:n]), "READY=1")
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Unsetenv("WATCHDOG_USEC")
+	assert.Equal(t, SdWatchdogInterval(), time.Duration(0))
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	assert.Equal(t, SdWatchdogInterval(), 30*time.Second)
+
+	// meant for another process
+	os.Setenv("WATCHDOG_PID", "1")
+	assert.Equal(t, SdWatchdogInterval(), time.Duration(0))
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	assert.Equal(t, SdWatchdogInterval(), 30*time.Second)
+}
+
+func TestSdWatchdogHeartbeats(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.Equal(t, err, nil)
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	// a watcher stuck for longer than the interval
+	stuck := &Heartbeat{last: time.Now().Add(-time.Minute)}
+	stop := make(chan bool)
+	defer close(stop)
+	go SdWatchdog(40*time.Millisecond, []*Heartbeat{stuck}, nil, stop)
+
+	buffer := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(buffer)
+	assert.NotEqual(t, err, nil)
+
+	// and making progress again
+	stuck.Beat()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buffer)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(buffer[:n]), "WATCHDOG=1")
+}
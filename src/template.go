@@ -0,0 +1,283 @@
+package src
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type TemplateRenderer struct {
+	TemplateFile *string
+	OutputFile   *string
+	TemplateDir  *string
+
+	// Guards the cached templates, as every -watch renders with the same
+	// renderer
+	mutex    sync.Mutex
+	template *parsedTemplate
+	// The templates of TemplateDir, by path
+	templates map[string]*parsedTemplate
+}
+
+// A template along with the modification time of the file it was parsed from.
+type parsedTemplate struct {
+	template *template.Template
+	modTime  time.Time
+}
+
+// Executes the user template against the data. Nested values are reached
+// with the usual dot notation, like {{ .database.pool }}. The template is
+// parsed again whenever its file changes on disk.
+func (renderer *TemplateRenderer) Render(env Env) ([]byte, error) {
+	path := env.outputPath(renderer.File())
+	env.Logger.Infof("[TEMPLATE RENDERER] Rendering %s to %s", *renderer.TemplateFile, path)
+
+	renderer.mutex.Lock()
+	parsed, err := loadTemplate(*renderer.TemplateFile, renderer.template, env.Logger)
+	if err == nil {
+		renderer.template = parsed
+	}
+	renderer.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := parsed.template.Execute(&out, env.Data); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// Renders the output files. With TemplateDir, every .tmpl file under it is
+// executed into the file next to it without the suffix (database.yml.tmpl
+// renders database.yml). Templates that fail to parse or execute are logged
+// and skipped, so they never hold back the others. Without TemplateDir it's
+// just the single file of Render.
+func (renderer *TemplateRenderer) RenderFiles(env Env) (map[string][]byte, error) {
+	if !renderer.dirMode() {
+		out, err := renderer.Render(env)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{env.OutputFile(): out}, nil
+	}
+
+	paths, err := templatePaths(*renderer.TemplateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte, len(paths))
+	renderer.mutex.Lock()
+	templates := make(map[string]*parsedTemplate, len(paths))
+	for _, path := range paths {
+		if parsed, err := loadTemplate(path, renderer.templates[path], env.Logger); err != nil {
+			env.Logger.Errorf("[TEMPLATE RENDERER] Skipping %s: %s", path, err)
+		} else {
+			templates[path] = parsed
+		}
+	}
+	renderer.templates = templates
+	renderer.mutex.Unlock()
+
+	for _, path := range paths {
+		parsed, ok := templates[path]
+		if !ok {
+			continue
+		}
+		output := strings.TrimSuffix(path, ".tmpl")
+		env.Logger.Infof("[TEMPLATE RENDERER] Rendering %s to %s", path, output)
+
+		var out bytes.Buffer
+		if err := parsed.template.Execute(&out, env.Data); err != nil {
+			env.Logger.Errorf("[TEMPLATE RENDERER] Skipping %s: %s", path, err)
+			continue
+		}
+		files[output] = out.Bytes()
+	}
+
+	return files, nil
+}
+
+// The single output file, or none with TemplateDir.
+func (renderer *TemplateRenderer) File() string {
+	if renderer.dirMode() {
+		return ""
+	}
+	return *renderer.OutputFile
+}
+
+func (renderer *TemplateRenderer) RegisterFlags() {
+	renderer.TemplateFile = flag.String("template", "", "The Go template used by the template renderer")
+	renderer.OutputFile = flag.String("template-output", "config/config.yml", "The output of the template renderer")
+	renderer.TemplateDir = flag.String("template-dir", "", "Render every .tmpl file in this directory next to it, without the suffix (instead of -template)")
+}
+
+// Parses the templates for the first time, failing if any is missing or
+// invalid.
+func (renderer *TemplateRenderer) Open() error {
+	if renderer.dirMode() {
+		paths, err := templatePaths(*renderer.TemplateDir)
+		if err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("no .tmpl files in %s", *renderer.TemplateDir)
+		}
+
+		renderer.templates = make(map[string]*parsedTemplate, len(paths))
+		for _, path := range paths {
+			parsed, err := loadTemplate(path, nil, nil)
+			if err != nil {
+				return err
+			}
+			renderer.templates[path] = parsed
+		}
+		return nil
+	}
+
+	if *renderer.TemplateFile == "" {
+		return fmt.Errorf("-template or -template-dir is required")
+	}
+
+	parsed, err := loadTemplate(*renderer.TemplateFile, nil, nil)
+	if err != nil {
+		return err
+	}
+	renderer.template = parsed
+	return nil
+}
+
+func (renderer *TemplateRenderer) dirMode() bool {
+	return renderer.TemplateDir != nil && *renderer.TemplateDir != ""
+}
+
+// Lists the .tmpl files under dir, sorted.
+func templatePaths(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".tmpl") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+// Parses the template file, unless it didn't change since it was parsed into
+// cached.
+func loadTemplate(path string, cached *parsedTemplate, logger *Logger) (*parsedTemplate, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached != nil && info.ModTime().Equal(cached.modTime) {
+		return cached, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=zero").Funcs(templateFuncs).Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	if cached != nil {
+		logger.Infof("[TEMPLATE RENDERER] Reloaded %s", path)
+	}
+	return &parsedTemplate{template: tmpl, modTime: info.ModTime()}, nil
+}
+
+// The functions templates can call, besides the text/template builtins:
+//
+//	default  {{ .pool | default "5" }} gives "5" when pool is missing or empty
+//	b64dec   decodes a base64 value, failing the render if it isn't valid
+//	toJson   encodes a value (like a whole subtree) as JSON on a single line
+//	toYaml   encodes a value as YAML, with sorted keys and no final newline
+//	env      reads an environment variable of the daemon, "" when unset
+//	quote    double quotes a value, escaping it like a Go string
+var templateFuncs = template.FuncMap{
+	"default": templateDefault,
+	"b64dec":  templateB64dec,
+	"toJson":  templateToJson,
+	"toYaml":  templateToYaml,
+	"env":     os.Getenv,
+	"quote":   templateQuote,
+}
+
+func templateDefault(fallback interface{}, value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return fallback
+	case string:
+		if v == "" {
+			return fallback
+		}
+	case bool:
+		if !v {
+			return fallback
+		}
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return fallback
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			return fallback
+		}
+	}
+	return value
+}
+
+func templateB64dec(value string) (string, error) {
+	out, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("b64dec: %s", err)
+	}
+	return string(out), nil
+}
+
+func templateToJson(value interface{}) (string, error) {
+	out, err := json.Marshal(value)
+	return string(out), err
+}
+
+func templateToYaml(value interface{}) (string, error) {
+	out, err := yaml.Marshal(sortedYaml(value))
+	return strings.TrimSuffix(string(out), "\n"), err
+}
+
+func templateQuote(value interface{}) string {
+	if value == nil {
+		return `""`
+	}
+	return strconv.Quote(fmt.Sprint(value))
+}
+
+func init() {
+	templateRenderer := TemplateRenderer{}
+	RegisterRenderer("template", &templateRenderer)
+}
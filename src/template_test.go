@@ -0,0 +1,171 @@
+package src
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestTemplateRender(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	tmpl := filepath.Join(dir, "database.yml.tmpl")
+	output := filepath.Join(dir, "database.yml")
+	renderer := TemplateRenderer{TemplateFile: &tmpl, OutputFile: &output}
+
+	ioutil.WriteFile(tmpl, []byte("production:\n  pool: {{ .database.pool }}\n"), 0644)
+	assert.Equal(t, renderer.Open(), nil)
+
+	env := Env{Data: map[string]interface{}{"database": map[string]interface{}{"pool": "5"}}}
+	out, err := renderer.Render(env)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), "production:\n  pool: 5\n")
+
+	// the template is parsed again when it changes on disk
+	ioutil.WriteFile(tmpl, []byte("{{ range $k, $v := .database }}{{ $k }}={{ $v }}{{ end }}\n"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(tmpl, later, later)
+	out, err = renderer.Render(env)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), "pool=5\n")
+}
+
+func TestTemplateRenderConcurrently(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	tmpl := filepath.Join(dir, "database.yml.tmpl")
+	output := filepath.Join(dir, "database.yml")
+	renderer := TemplateRenderer{TemplateFile: &tmpl, OutputFile: &output}
+	ioutil.WriteFile(tmpl, []byte("pool: {{ .pool }}\n"), 0644)
+	assert.Equal(t, renderer.Open(), nil)
+
+	// like several -watch sharing the renderer, while the template changes
+	var rendering sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		rendering.Add(1)
+		go func(i int) {
+			defer rendering.Done()
+			for j := 0; j < 20; j++ {
+				out, err := renderer.Render(Env{Data: map[string]interface{}{"pool": i}})
+				assert.Equal(t, err, nil)
+				assert.Equal(t, strings.HasSuffix(string(out), fmt.Sprintf(": %d\n", i)), true)
+			}
+		}(i)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(tmpl, later, later)
+	rendering.Wait()
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	tmpl := filepath.Join(dir, "config.tmpl")
+	output := filepath.Join(dir, "config")
+	renderer := TemplateRenderer{TemplateFile: &tmpl, OutputFile: &output}
+	os.Setenv("RAILS_CONFIGD_TEST_REGION", "eu-west-1")
+	defer os.Unsetenv("RAILS_CONFIGD_TEST_REGION")
+
+	ioutil.WriteFile(tmpl, []byte(`pool: {{ .pool | default "5" }}
+timeout: {{ .timeout | default "10" }}
+password: {{ .password | b64dec }}
+database: {{ .database | toJson }}
+{{ .database | toYaml }}
+region: {{ env "RAILS_CONFIGD_TEST_REGION" }}
+name: {{ .name | quote }}
+`), 0644)
+	assert.Equal(t, renderer.Open(), nil)
+
+	env := Env{Data: map[string]interface{}{
+		"timeout":  "30",
+		"password": "c2VjcmV0",
+		"database": map[string]interface{}{"host": "db01", "adapter": "postgresql"},
+		"name":     `my "app"`,
+	}}
+	out, err := renderer.Render(env)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `pool: 5
+timeout: 30
+password: secret
+database: {"adapter":"postgresql","host":"db01"}
+adapter: postgresql
+host: db01
+region: eu-west-1
+name: "my \"app\""
+`)
+
+	// invalid base64 fails the render
+	env.Data["password"] = "not base64!"
+	_, err = renderer.Render(env)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestTemplateOpenInvalid(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	tmpl := filepath.Join(dir, "missing.tmpl")
+	output := filepath.Join(dir, "out")
+	renderer := TemplateRenderer{TemplateFile: &tmpl, OutputFile: &output}
+	assert.NotEqual(t, renderer.Open(), nil)
+
+	ioutil.WriteFile(tmpl, []byte("{{ .broken "), 0644)
+	assert.NotEqual(t, renderer.Open(), nil)
+}
+
+func TestTemplateRenderDir(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "initializers"), 0755)
+	database := filepath.Join(dir, "database.yml.tmpl")
+	redis := filepath.Join(dir, "initializers", "redis.rb.tmpl")
+	ioutil.WriteFile(database, []byte("production:\n  pool: {{ .database.pool }}\n"), 0644)
+	ioutil.WriteFile(redis, []byte("$redis = Redis.new(url: {{ printf \"%q\" .redis.url }})\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a template"), 0644)
+
+	empty := ""
+	renderer := TemplateRenderer{TemplateFile: &empty, OutputFile: &empty, TemplateDir: &dir}
+	assert.Equal(t, renderer.Open(), nil)
+	assert.Equal(t, renderer.File(), "")
+
+	env := Env{Renderer: &renderer, Data: map[string]interface{}{
+		"database": map[string]interface{}{"pool": "5"},
+		"redis":    map[string]interface{}{"url": "redis://cache:6379"},
+	}}
+	changed, err := env.render()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, true)
+
+	out, _ := ioutil.ReadFile(filepath.Join(dir, "database.yml"))
+	assert.Equal(t, string(out), "production:\n  pool: 5\n")
+	out, _ = ioutil.ReadFile(filepath.Join(dir, "initializers", "redis.rb"))
+	assert.Equal(t, string(out), "$redis = Redis.new(url: \"redis://cache:6379\")\n")
+
+	// a template failing to execute is skipped, the others are still rendered
+	env.Data = map[string]interface{}{
+		"database": map[string]interface{}{"pool": "10"},
+		"redis":    "redis://cache:6379",
+	}
+	changed, err = env.render()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, true)
+	out, _ = ioutil.ReadFile(filepath.Join(dir, "database.yml"))
+	assert.Equal(t, string(out), "production:\n  pool: 10\n")
+	out, _ = ioutil.ReadFile(filepath.Join(dir, "initializers", "redis.rb"))
+	assert.Equal(t, string(out), "$redis = Redis.new(url: \"redis://cache:6379\")\n")
+
+	// but one that doesn't parse fails at startup
+	ioutil.WriteFile(redis, []byte("{{ .broken "), 0644)
+	assert.NotEqual(t, renderer.Open(), nil)
+}
@@ -0,0 +1,51 @@
+package src
+
+import (
+	"bytes"
+	"flag"
+
+	"github.com/BurntSushi/toml"
+)
+
+type TomlRenderer struct {
+	TomlFile *string
+}
+
+// Renders the data as a TOML document, where nested maps become [section]
+// tables. The encoder sorts keys and writes plain values before the tables
+// of the same parent, as TOML requires. Anything the encoder can't
+// represent (like arrays mixing tables and plain values) is returned as an
+// error and the previous file is left untouched.
+func (renderer *TomlRenderer) Render(env Env) ([]byte, error) {
+	path := env.outputPath(renderer.File())
+	env.Logger.Infof("[TOML RENDERER] Rendering to %s", path)
+
+	data, err := env.sectionData(path, "TOML", parseTomlSections)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := toml.NewEncoder(&out).Encode(data); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+func (renderer *TomlRenderer) File() string {
+	return *renderer.TomlFile
+}
+
+func (renderer *TomlRenderer) Commented() bool {
+	return true
+}
+
+func (renderer *TomlRenderer) RegisterFlags() {
+	renderer.TomlFile = flag.String("toml-file", "config/config.toml", "The output of the TOML file")
+}
+
+func init() {
+	tomlRenderer := TomlRenderer{}
+	RegisterRenderer("toml", &tomlRenderer)
+}
@@ -2,7 +2,6 @@ package src
 
 import (
 	"flag"
-	"log"
 	"os"
 )
 
@@ -10,17 +9,16 @@ type TouchReloader struct {
 	TouchFile *string
 }
 
-func (reloader *TouchReloader) Reload() {
-	log.Printf("[TOUCH RELOADER] Touching %s", *reloader.TouchFile)
+func (reloader *TouchReloader) Reload(env Env) error {
+	env.Logger.Infof("[TOUCH RELOADER] Touching %s", *reloader.TouchFile)
 
 	file, err := os.OpenFile(*reloader.TouchFile, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
-	defer file.Close()
-
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer file.Close()
 
-	file.Truncate(0)
+	return file.Truncate(0)
 }
 
 func (reloader *TouchReloader) RegisterFlags() {
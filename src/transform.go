@@ -0,0 +1,76 @@
+package src
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// The values KeyTransform may take
+var KeyTransforms = []string{"none", "underscore", "camelize", "dasherize"}
+
+// Returns an error unless name is one of KeyTransforms.
+func CheckKeyTransform(name string) error {
+	for _, transform := range KeyTransforms {
+		if name == transform {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown key transform %q, should be one of %s", name, strings.Join(KeyTransforms, ", "))
+}
+
+// Renames a key segment as KeyTransform says, the way ActiveSupport does:
+// underscore turns MaxPool and max-pool into max_pool, dasherize into
+// max-pool and camelize into maxPool.
+func (env *Env) transformKey(key string) string {
+	switch env.KeyTransform {
+	case "underscore":
+		return strings.Join(keyWords(key), "_")
+	case "dasherize":
+		return strings.Join(keyWords(key), "-")
+	case "camelize":
+		words := keyWords(key)
+		for i := 1; i < len(words); i++ {
+			word := []rune(words[i])
+			word[0] = unicode.ToUpper(word[0])
+			words[i] = string(word)
+		}
+		return strings.Join(words, "")
+	default:
+		return key
+	}
+}
+
+// Splits a key into its lower case words, separated by underscores, dashes or
+// a change to upper case (keeping acronyms like HTTP together).
+func keyWords(key string) []string {
+	var words []string
+	var word []rune
+	runes := []rune(key)
+	for i, r := range runes {
+		if r == '_' || r == '-' {
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word = nil
+			continue
+		}
+		if unicode.IsUpper(r) && len(word) > 0 {
+			previous := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if !unicode.IsUpper(previous) || nextLower {
+				words = append(words, string(word))
+				word = nil
+			}
+		}
+		word = append(word, unicode.ToLower(r))
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	if len(words) == 0 {
+		// only separators, nothing to rename
+		return []string{key}
+	}
+	return words
+}
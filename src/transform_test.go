@@ -0,0 +1,34 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestTransformKey(t *testing.T) {
+	tests := []struct {
+		transform, key, renamed string
+	}{
+		{"none", "max-Pool", "max-Pool"},
+		{"underscore", "a-b-c", "a_b_c"},
+		{"underscore", "MaxPool", "max_pool"},
+		{"underscore", "HTTPServer", "http_server"},
+		{"underscore", "0", "0"},
+		{"dasherize", "max_pool", "max-pool"},
+		{"dasherize", "maxPool", "max-pool"},
+		{"camelize", "max_pool-size", "maxPoolSize"},
+		{"camelize", "MaxPool", "maxPool"},
+		{"camelize", "_", "_"},
+	}
+
+	for _, test := range tests {
+		env := Env{KeyTransform: test.transform}
+		assert.Equal(t, env.transformKey(test.key), test.renamed, test.transform+" "+test.key)
+	}
+}
+
+func TestCheckKeyTransform(t *testing.T) {
+	assert.Equal(t, CheckKeyTransform("camelize"), nil)
+	assert.NotEqual(t, CheckKeyTransform("titleize"), nil)
+}
@@ -0,0 +1,56 @@
+package src
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// A key of a flat key/value store (like Consul or etcd v3), where slashes in
+// the keys stand for directories.
+type flatKey struct {
+	Key   string
+	Value string
+	Index uint64
+	// Only stands for the directory itself
+	Dir bool
+}
+
+// Builds the etcd directory holding the keys under prefix, creating the
+// directories their slashes imply.
+func flatTree(prefix string, keys []flatKey) *etcd.Node {
+	root := &etcd.Node{Key: "/" + cleanKey(prefix), Dir: true}
+	dirs := map[string]*etcd.Node{"": root}
+
+	var dir func(parts []string) *etcd.Node
+	dir = func(parts []string) *etcd.Node {
+		key := strings.Join(parts, "/")
+		if node, ok := dirs[key]; ok {
+			return node
+		}
+		parent := dir(parts[:len(parts)-1])
+		node := &etcd.Node{Key: strings.TrimSuffix(root.Key, "/") + "/" + key, Dir: true}
+		parent.Nodes = append(parent.Nodes, node)
+		dirs[key] = node
+		return node
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	for _, key := range keys {
+		naked := strings.TrimPrefix(strings.TrimPrefix(cleanKey(key.Key), cleanKey(prefix)), "/")
+		if naked == "" {
+			continue
+		}
+		parts := strings.Split(naked, "/")
+
+		if key.Dir {
+			dir(parts)
+			continue
+		}
+		parent := dir(parts[:len(parts)-1])
+		parent.Nodes = append(parent.Nodes, &etcd.Node{Key: "/" + cleanKey(key.Key), Value: key.Value, ModifiedIndex: key.Index})
+	}
+
+	return root
+}
@@ -0,0 +1,62 @@
+package src
+
+import (
+	"flag"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+type UnicornReloader struct {
+	PidFile *string
+	Grace   *time.Duration
+}
+
+// Zero downtime reload for Unicorn: USR2 makes the master re-exec itself and
+// move its pidfile to <pidfile>.oldbin. Once the grace period is over and the
+// new master is up, the old master is sent QUIT. If the new master never
+// shows up, the old one is left running.
+func (reloader *UnicornReloader) Reload(env Env) error {
+	oldPid, err := readPidFile(*reloader.PidFile)
+	if err != nil {
+		return err
+	}
+
+	env.Logger.Infof("[UNICORN RELOADER] Sending USR2 to master %d", oldPid)
+	if err := signalProcess(oldPid, syscall.SIGUSR2); err != nil {
+		return err
+	}
+
+	env.Logger.Infof("[UNICORN RELOADER] Waiting %s for the new master", *reloader.Grace)
+	time.Sleep(*reloader.Grace)
+
+	newPid, err := readPidFile(*reloader.PidFile)
+	if err == nil && newPid == oldPid {
+		err = fmt.Errorf("pidfile still points to the old master")
+	}
+	if err == nil {
+		err = signalProcess(newPid, syscall.Signal(0))
+	}
+	if err != nil {
+		return fmt.Errorf("new master didn't start, keeping master %d: %s", oldPid, err)
+	}
+	env.Logger.Infof("[UNICORN RELOADER] New master %d is up", newPid)
+
+	oldbinPid, err := readPidFile(*reloader.PidFile + ".oldbin")
+	if err != nil {
+		return err
+	}
+
+	env.Logger.Infof("[UNICORN RELOADER] Sending QUIT to old master %d", oldbinPid)
+	return signalProcess(oldbinPid, syscall.SIGQUIT)
+}
+
+func (reloader *UnicornReloader) RegisterFlags() {
+	reloader.PidFile = flag.String("unicorn-pidfile", "tmp/pids/unicorn.pid", "The pidfile of the Unicorn master")
+	reloader.Grace = flag.Duration("unicorn-grace", 10*time.Second, "How long to wait for the new Unicorn master before stopping the old one")
+}
+
+func init() {
+	unicornReloader := UnicornReloader{}
+	RegisterReloader("unicorn", &unicornReloader)
+}
@@ -0,0 +1,71 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Parses the rendered configuration back, according to the extension of the
+// file it's written to (.yml, .yaml, .json or .toml), so a broken value never
+// makes it to the live file. Other files aren't checked.
+func validateConfig(path string, out []byte) error {
+	var parsed interface{}
+	var err error
+	var format string
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		format = "YAML"
+		err = yaml.Unmarshal(out, &parsed)
+	case ".json":
+		format = "JSON"
+		err = json.Unmarshal(out, &parsed)
+	case ".toml":
+		format = "TOML"
+		var table map[string]interface{}
+		_, err = toml.Decode(string(out), &table)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s would not be valid %s, keeping the previous file: %s", path, format, err)
+	}
+	return nil
+}
+
+// Runs ValidateCommand against the file at path, just written by the renderer.
+// If it fails the previous contents of the file are put back, or the file is
+// removed if it didn't exist before.
+func (env *Env) validateWritten(path string, previous []byte, existed bool) error {
+	err := runCommand(*env, "VALIDATE", env.ValidateCommand, env.ValidateTimeout, env.drainTimeout())
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("%s rejected %s: %s", env.ValidateCommand, path, err)
+
+	if restoreErr := env.restoreFile(path, previous, existed); restoreErr != nil {
+		return fmt.Errorf("%s, and cannot restore the previous file: %s", err, restoreErr)
+	}
+	return err
+}
+
+// Puts back the previous contents of the file at path, or removes it if it
+// didn't exist before.
+func (env *Env) restoreFile(path string, previous []byte, existed bool) error {
+	if !existed {
+		os.Remove(path)
+		return nil
+	}
+	uid, gid, err := lookupOwner(env.FileOwner, env.FileGroup)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, previous, 0644, uid, gid)
+}
@@ -0,0 +1,67 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestValidateConfig(t *testing.T) {
+	assert.Equal(t, validateConfig("config.yml", []byte("production:\n  pool: 5\n")), nil)
+	assert.Equal(t, validateConfig("config.json", []byte(`{"pool": 5}`)), nil)
+	assert.Equal(t, validateConfig(".env", []byte("not: valid: yaml")), nil)
+
+	assert.NotEqual(t, validateConfig("config.yml", []byte("production:\n  password: a: b\n")), nil)
+	assert.NotEqual(t, validateConfig("config.json", []byte(`{"pool": 5`)), nil)
+}
+
+func TestWriteConfigKeepsValidFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "database.yml")
+	ioutil.WriteFile(file, []byte("production:\n  pool: 5\n"), 0644)
+
+	env := Env{Status: new(Status)}
+	changed, err := env.writeConfig(file, []byte("production:\n  password: a: b\n"))
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, changed, false)
+	assert.Equal(t, env.Status.LastValidation(), err)
+
+	out, _ := ioutil.ReadFile(file)
+	assert.Equal(t, string(out), "production:\n  pool: 5\n")
+
+	_, err = env.writeConfig(file, []byte("production:\n  pool: 10\n"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, env.Status.LastValidation(), nil)
+}
+
+func TestCycleValidateCommand(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(file, []byte("{\n  \"pool\": \"5\"\n}\n"), 0644)
+	reloader := new(MockReloader)
+	env := Env{
+		Renderer:        &JsonRenderer{JsonFile: &file},
+		Reloader:        reloader,
+		ValidateCommand: `! grep -q broken "$RAILS_CONFIGD_FILE"`,
+		Data:            map[string]interface{}{"pool": "broken"},
+	}
+
+	// a rejected file is replaced by the previous one
+	assert.NotEqual(t, env.Cycle(), nil)
+	assert.Equal(t, reloader.Called, false)
+	out, _ := ioutil.ReadFile(file)
+	assert.Equal(t, string(out), "{\n  \"pool\": \"5\"\n}\n")
+
+	env.Data = map[string]interface{}{"pool": "10"}
+	assert.Equal(t, env.Cycle(), nil)
+	assert.Equal(t, reloader.Called, true)
+	out, _ = ioutil.ReadFile(file)
+	assert.Equal(t, string(out), "{\n  \"pool\": \"10\"\n}\n")
+}
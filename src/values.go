@@ -0,0 +1,144 @@
+package src
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Numbers as a human would write them: no leading zeros, hex or underscores
+var numberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// The numbers of numberPattern without a fraction or exponent
+var integerPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+
+// Converts a raw etcd value into the value stored in Data. With ExpandJson,
+// values holding a JSON object or array are stored parsed. With CoerceTypes,
+// values that look like integers, floats or booleans are stored as such,
+// unless the key matches one of StringKeys.
+func (env *Env) value(parts []string, value string) interface{} {
+	if env.ExpandJson {
+		if expanded, ok := expandJson(value); ok {
+			return expanded
+		}
+	}
+
+	if !env.CoerceTypes || matchKey(env.StringKeys, parts) {
+		return value
+	}
+
+	return coerceValue(value)
+}
+
+// Reports (and logs) whether the raw value of the key made of parts is over
+// MaxValueSize.
+func (env *Env) oversized(parts []string, value string) bool {
+	if !env.tooLarge(value) {
+		return false
+	}
+	env.Logger.Errorf("[ENV] Rejecting the %d bytes value of %s, -max-value-size is %d", len(value), strings.Join(parts, "/"), env.MaxValueSize)
+	return true
+}
+
+// Reports whether the raw value is over MaxValueSize.
+func (env *Env) tooLarge(value string) bool {
+	return env.MaxValueSize > 0 && len(value) > env.MaxValueSize
+}
+
+// Parses value if it holds a JSON object or array. Plain JSON scalars (like
+// "5" or "true") are left to the type coercion. Numbers inside become
+// integers or floats the way coerced values do, so 5 stays an integer.
+func expandJson(value string) (interface{}, bool) {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+	var expanded interface{}
+	if err := decoder.Decode(&expanded); err != nil || decoder.More() {
+		return nil, false
+	}
+	return coerceNumbers(expanded), true
+}
+
+// Replaces the json.Numbers in value by what coerceValue makes of them.
+func coerceNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = coerceNumbers(child)
+		}
+	case []interface{}:
+		for i, child := range value {
+			value[i] = coerceNumbers(child)
+		}
+	case json.Number:
+		return coerceValue(value.String())
+	}
+	return value
+}
+
+// Parses value as a boolean, an integer or a float, returning it unchanged
+// if it's none of them. Values with leading zeros (like zip codes), version
+// strings like "1.2.0-rc" and integers too large for an int64 (like some IDs,
+// which a float64 would round) stay strings.
+func coerceValue(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if !numberPattern.MatchString(value) {
+		return value
+	}
+	if integerPattern.MatchString(value) {
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+		return value
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+
+	return value
+}
+
+// Reports whether the key made of parts matches any of the glob patterns.
+func matchKey(patterns []string, parts []string) bool {
+	key := strings.Join(parts, "/")
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the key made of parts, or any directory above it, matches
+// any of the glob patterns.
+func matchPrefix(patterns []string, parts []string) bool {
+	for i := 1; i <= len(parts); i++ {
+		if matchKey(patterns, parts[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the key made of parts is kept out of the data: it matches
+// Exclude, or Include is set and it doesn't match it. Patterns matching a
+// directory apply to everything inside it, and Exclude wins over Include.
+func (env *Env) filtered(parts []string) bool {
+	if matchPrefix(env.Exclude, parts) {
+		return true
+	}
+	return len(env.Include) > 0 && !matchPrefix(env.Include, parts)
+}
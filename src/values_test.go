@@ -0,0 +1,117 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+func TestCoerceValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected interface{}
+	}{
+		{"5", int64(5)},
+		{"-12", int64(-12)},
+		{"0", int64(0)},
+		{"1.5", 1.5},
+		{"1e3", 1000.0},
+		{"9223372036854775807", int64(9223372036854775807)},
+		{"9223372036854775808", "9223372036854775808"},
+		{"-123456789012345678901234", "-123456789012345678901234"},
+		{"true", true},
+		{"false", false},
+		{"1.2.0-rc", "1.2.0-rc"},
+		{"02134", "02134"},
+		{"0x1F", "0x1F"},
+		{"True", "True"},
+		{"localhost", "localhost"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, coerceValue(test.value), test.expected, test.value)
+	}
+}
+
+func TestCoerceTypes(t *testing.T) {
+	env := Env{CoerceTypes: true, StringKeys: []string{"address/*"}}
+
+	poolNode := etcd.Node{Key: "/rails/database/pool", Value: "5"}
+	zipNode := etcd.Node{Key: "/rails/address/zip", Value: "12345"}
+	databaseNode := etcd.Node{Key: "/rails/database", Dir: true, Nodes: etcd.Nodes{&poolNode}}
+	addressNode := etcd.Node{Key: "/rails/address", Dir: true, Nodes: etcd.Nodes{&zipNode}}
+	dirNode := etcd.Node{Dir: true, Nodes: etcd.Nodes{&databaseNode, &addressNode}}
+
+	data := map[string]interface{}{}
+	env.BuildData(dirNode, "/rails", data)
+
+	assert.Equal(t, data["database"].(map[string]interface{})["pool"], int64(5))
+	assert.Equal(t, data["address"].(map[string]interface{})["zip"], "12345")
+
+	env.UpdateData([]string{"database", "ssl"}, "true", "set", data)
+	assert.Equal(t, data["database"].(map[string]interface{})["ssl"], true)
+
+	env.UpdateData([]string{"address", "zip"}, "54321", "set", data)
+	assert.Equal(t, data["address"].(map[string]interface{})["zip"], "54321")
+}
+
+func TestExpandJson(t *testing.T) {
+	env := Env{ExpandJson: true}
+	data := map[string]interface{}{}
+
+	env.UpdateData([]string{"features"}, `{"beta": true, "flags": ["a", "b"], "pool": 5, "ratio": 0.5, "id": 12345678901234567890}`, "set", data)
+	features := data["features"].(map[string]interface{})
+	assert.Equal(t, features["beta"], true)
+	assert.Equal(t, features["flags"], []interface{}{"a", "b"})
+	assert.Equal(t, features["pool"], int64(5))
+	assert.Equal(t, features["ratio"], 0.5)
+	assert.Equal(t, features["id"], "12345678901234567890")
+
+	env.UpdateData([]string{"broken"}, `{"beta": `, "set", data)
+	assert.Equal(t, data["broken"], `{"beta": `)
+
+	env.UpdateData([]string{"pool"}, "5", "set", data)
+	assert.Equal(t, data["pool"], "5")
+
+	env.UpdateData([]string{"features"}, "", "delete", data)
+	_, ok := data["features"]
+	assert.Equal(t, ok, false)
+}
+
+func TestFilters(t *testing.T) {
+	env := Env{Include: []string{"database", "cache/*"}, Exclude: []string{"database/replica", "*/debug"}}
+
+	host := etcd.Node{Key: "/rails/database/host", Value: "db01"}
+	replicaHost := etcd.Node{Key: "/rails/database/replica/host", Value: "db02"}
+	replica := etcd.Node{Key: "/rails/database/replica", Dir: true, Nodes: etcd.Nodes{&replicaHost}}
+	database := etcd.Node{Key: "/rails/database", Dir: true, Nodes: etcd.Nodes{&host, &replica}}
+	cacheUrl := etcd.Node{Key: "/rails/cache/url", Value: "redis://cache01"}
+	cacheDebug := etcd.Node{Key: "/rails/cache/debug", Value: "true"}
+	cache := etcd.Node{Key: "/rails/cache", Dir: true, Nodes: etcd.Nodes{&cacheUrl, &cacheDebug}}
+	opsHost := etcd.Node{Key: "/rails/ops/host", Value: "ops01"}
+	ops := etcd.Node{Key: "/rails/ops", Dir: true, Nodes: etcd.Nodes{&opsHost}}
+	dirNode := etcd.Node{Dir: true, Nodes: etcd.Nodes{&database, &cache, &ops}}
+
+	data := map[string]interface{}{}
+	env.BuildData(dirNode, "/rails", data)
+
+	// the excluded directory is skipped wholesale, and cache/debug matches both
+	assert.Equal(t, data, map[string]interface{}{
+		"database": map[string]interface{}{"host": "db01"},
+		"cache":    map[string]interface{}{"url": "redis://cache01"},
+	})
+
+	env.UpdateData([]string{"database", "replica", "port"}, "5432", "set", data)
+	env.UpdateData([]string{"cache", "debug"}, "false", "set", data)
+	env.UpdateData([]string{"ops", "port"}, "22", "set", data)
+	env.UpdateData([]string{"database", "pool"}, "5", "set", data)
+	assert.Equal(t, data, map[string]interface{}{
+		"database": map[string]interface{}{"host": "db01", "pool": "5"},
+		"cache":    map[string]interface{}{"url": "redis://cache01"},
+	})
+
+	env.UpdateData([]string{"cache"}, "", "delete", data)
+	assert.Equal(t, data["cache"], nil)
+}
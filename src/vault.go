@@ -0,0 +1,137 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values starting with this are references to Vault secrets, like
+// vault:secret/data/myapp#db_password
+const vaultPrefix = "vault:"
+
+// VaultResolver fetches the secrets that values reference, so they never have
+// to be stored in etcd. Secrets are cached for TTL, and when Vault can't be
+// reached the last value fetched keeps being used.
+type VaultResolver struct {
+	Addr  string
+	Token string
+	TTL   time.Duration
+
+	client  *http.Client
+	mutex   sync.Mutex
+	secrets map[string]vaultSecret
+}
+
+// The fields of a secret, and when they were fetched
+type vaultSecret struct {
+	fields  map[string]string
+	fetched time.Time
+}
+
+func NewVaultResolver(addr, token string, ttl time.Duration) *VaultResolver {
+	return &VaultResolver{
+		Addr:    strings.TrimRight(addr, "/"),
+		Token:   token,
+		TTL:     ttl,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		secrets: make(map[string]vaultSecret),
+	}
+}
+
+// Returns the secret a vault:<path>#<field> reference points to.
+func (vault *VaultResolver) Resolve(reference string, logger *Logger) (string, error) {
+	i := strings.LastIndex(reference, "#")
+	if i < len(vaultPrefix) {
+		return "", fmt.Errorf("%s should be vault:<path>#<field>", reference)
+	}
+	path, field := strings.Trim(reference[len(vaultPrefix):i], "/"), reference[i+1:]
+	if path == "" || field == "" {
+		return "", fmt.Errorf("%s should be vault:<path>#<field>", reference)
+	}
+
+	vault.mutex.Lock()
+	defer vault.mutex.Unlock()
+
+	secret, cached := vault.secrets[path]
+	if !cached || time.Since(secret.fetched) >= vault.TTL {
+		fields, err := vault.fetch(path)
+		if err != nil && !cached {
+			return "", fmt.Errorf("cannot read %s from Vault: %s", path, err)
+		}
+		if err != nil {
+			logger.Warnf("[VAULT] Cannot read %s, keeping the value read %s ago: %s", path, time.Since(secret.fetched), err)
+		} else {
+			secret = vaultSecret{fields: fields, fetched: time.Now()}
+			vault.secrets[path] = secret
+		}
+	}
+
+	value, ok := secret.fields[field]
+	if !ok {
+		return "", fmt.Errorf("%s has no %s field in Vault", path, field)
+	}
+	return value, nil
+}
+
+// Reads the secret at path. Secrets of the KV version 2 engine nest their
+// fields under data.data, those of version 1 under data.
+func (vault *VaultResolver) fetch(path string) (map[string]string, error) {
+	request, err := http.NewRequest("GET", vault.Addr+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("X-Vault-Token", vault.Token)
+
+	response, err := vault.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault answered %s", response.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	fields := make(map[string]string, len(data))
+	for key, value := range data {
+		if text, ok := value.(string); ok {
+			fields[key] = text
+		} else {
+			out, _ := json.Marshal(value)
+			fields[key] = string(out)
+		}
+	}
+	return fields, nil
+}
+
+// Replaces the secrets fetched so far in line by the mask.
+func (vault *VaultResolver) mask(line string) string {
+	vault.mutex.Lock()
+	defer vault.mutex.Unlock()
+
+	for _, secret := range vault.secrets {
+		for _, value := range secret.fields {
+			if value != "" {
+				line = strings.Replace(line, value, secretMask, -1)
+			}
+		}
+	}
+	return line
+}
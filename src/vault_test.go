@@ -0,0 +1,80 @@
+package src
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestVaultResolve(t *testing.T) {
+	requests := 0
+	down := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if down || r.Header.Get("X-Vault-Token") != "s3cr3t" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/myapp":
+			w.Write([]byte(`{"data": {"data": {"db_password": "hunter2", "port": 5432}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/legacy":
+			w.Write([]byte(`{"data": {"api_key": "abc"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	vault := NewVaultResolver(server.URL, "s3cr3t", time.Hour)
+
+	value, err := vault.Resolve("vault:secret/data/myapp#db_password", nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, value, "hunter2")
+	value, err = vault.Resolve("vault:secret/data/myapp#port", nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, value, "5432")
+	value, _ = vault.Resolve("vault:kv/legacy#api_key", nil)
+	assert.Equal(t, value, "abc")
+
+	// secrets are cached
+	assert.Equal(t, requests, 2)
+
+	_, err = vault.Resolve("vault:secret/data/myapp#missing", nil)
+	assert.Equal(t, err.Error(), "secret/data/myapp has no missing field in Vault")
+	_, err = vault.Resolve("vault:secret/data/myapp", nil)
+	assert.Equal(t, err.Error(), "vault:secret/data/myapp should be vault:<path>#<field>")
+	_, err = vault.Resolve("vault:secret/data/other#password", nil)
+	assert.Equal(t, err.Error(), "cannot read secret/data/other from Vault: vault answered 404 Not Found")
+
+	// once expired, the last value is kept while Vault is down
+	vault.TTL = 0
+	down = true
+	value, err = vault.Resolve("vault:secret/data/myapp#db_password", nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, value, "hunter2")
+	assert.Equal(t, requests, 4)
+}
+
+func TestVaultInterpolated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"data": {"db_password": "hunter2"}, "metadata": {}}}`))
+	}))
+	defer server.Close()
+
+	env := Env{Vault: NewVaultResolver(server.URL, "", time.Hour), Data: map[string]interface{}{
+		"database": map[string]interface{}{"password": "vault:secret/data/myapp#db_password", "pool": "5"},
+	}}
+
+	data, err := env.interpolated()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, data, map[string]interface{}{
+		"database": map[string]interface{}{"password": "hunter2", "pool": "5"},
+	})
+
+	// resolved secrets are masked whatever their key
+	assert.Equal(t, env.lineMasker(nil)(`  "pass": "hunter2",`), `  "pass": "***",`)
+}
@@ -0,0 +1,512 @@
+package src
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// Where the watcher gets the data from: the part of *etcd.Client it needs.
+// Other stores (like Consul) implement it by translating their keys and
+// changes into etcd nodes and responses, so the data is built the same way.
+type Backend interface {
+	SyncCluster() bool
+	Get(key string, sort, recursive bool) (*etcd.Response, error)
+	Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error)
+}
+
+// Watcher keeps the Env data in sync with the etcd directory, cycling the
+// Rails environment on every change. When the watch dies (for instance while
+// the etcd cluster rolls a node) it reconnects and resumes watching from the
+// last index it has seen, so no change is lost. If etcd already cleared that
+// index, the data is rebuilt from a fresh Get.
+type Watcher struct {
+	Client Backend
+	Env    *Env
+	// Delay before the first reconnect attempt, doubled after each failure
+	MinBackoff time.Duration
+	// Maximum delay between reconnect attempts
+	MaxBackoff time.Duration
+	// Give up after this many failed reconnect attempts in a row, 0 for never
+	MaxReconnectAttempts int
+	// Cycle only after no changes arrived for this long
+	Debounce time.Duration
+	// Cycle at most this long after the first of a burst of changes, even if
+	// changes keep arriving
+	DebounceMax time.Duration
+	// Rebuild the data from a fresh Get this often while watching, in case
+	// it drifted from etcd
+	ResyncInterval time.Duration
+	// How many etcd events can wait while the watcher cycles
+	EventBuffer int
+	// Every value received rebuilds the data from a fresh Get and cycles,
+	// reloading even if nothing changed (on SIGUSR1)
+	Rerender chan bool
+	// Whether EtcdDir is a directory. Otherwise it's a single key, stored in
+	// the data under its name.
+	Recursive bool
+	// Beaten every HeartbeatInterval while the watcher waits for changes or
+	// to reconnect, and after each cycle, for the systemd watchdog
+	Heartbeat         *Heartbeat
+	HeartbeatInterval time.Duration
+
+	// The last etcd index applied to the data
+	index uint64
+}
+
+func NewWatcher(client Backend, env *Env) *Watcher {
+	return &Watcher{Client: client, Env: env, MinBackoff: time.Second, MaxBackoff: time.Minute, Recursive: true}
+}
+
+// Reads the whole etcd directory and rebuilds the Env data from scratch, over
+// a copy of the Base data.
+func (watcher *Watcher) Sync() error {
+	data, index, err := watcher.read()
+	if err != nil {
+		return err
+	}
+	watcher.replace(data, index)
+	return nil
+}
+
+// Like Sync, but retries up to retries times when it fails (say etcd is still
+// starting), waiting MinBackoff and doubling it after each retry, up to
+// MaxBackoff. With a timeout, it gives up once it's spent that long (say etcd
+// accepted the connection but never answers), leaving the data alone.
+func (watcher *Watcher) SyncRetrying(retries int, timeout time.Duration) error {
+	type result struct {
+		data  map[string]interface{}
+		index uint64
+		err   error
+	}
+	done := make(chan result, 1)
+	abandoned := make(chan bool)
+
+	go func() {
+		backoff := watcher.MinBackoff
+
+		data, index, err := watcher.read()
+		for retry := 1; err != nil && retry <= retries; retry++ {
+			watcher.Env.Logger.Warnf("[WATCHER] %s, retrying in %s (%d/%d)", err, backoff, retry, retries)
+			select {
+			case <-time.After(backoff):
+			case <-abandoned:
+				return
+			}
+			backoff *= 2
+			if backoff > watcher.MaxBackoff {
+				backoff = watcher.MaxBackoff
+			}
+
+			data, index, err = watcher.read()
+		}
+		done <- result{data, index, err}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case result := <-done:
+		if result.err != nil {
+			return result.err
+		}
+		watcher.replace(result.data, result.index)
+		return nil
+	case <-expired:
+		close(abandoned)
+		return fmt.Errorf("etcd didn't answer within the -startup-timeout of %s", timeout)
+	}
+}
+
+// Connects to the etcd cluster and fetches the data.
+func (watcher *Watcher) read() (map[string]interface{}, uint64, error) {
+	if !watcher.Client.SyncCluster() {
+		return nil, 0, fmt.Errorf("cannot sync with etcd machines, please check -etcd")
+	}
+	return watcher.fetch()
+}
+
+// Replaces the Env data with data read at index.
+func (watcher *Watcher) replace(data map[string]interface{}, index uint64) {
+	watcher.Env.Data = data
+	watcher.Env.ChangedAt = time.Now()
+	watcher.Env.changedKeys = nil
+	watcher.index = index
+	watcher.Env.Status.SetConnected(true)
+	observeConnected(true)
+}
+
+// Builds the data from a fresh Get of the etcd directory, returning it along
+// with the etcd index it was read at.
+func (watcher *Watcher) fetch() (map[string]interface{}, uint64, error) {
+	response, err := watcher.Client.Get(*watcher.Env.EtcdDir, false, watcher.Recursive)
+	if err != nil {
+		return nil, 0, describeEtcdError(err)
+	}
+	if !response.Node.Dir && watcher.Recursive {
+		return nil, 0, fmt.Errorf("etcd-dir %s is a key, pass -recursive=false to watch a single key", *watcher.Env.EtcdDir)
+	}
+	if response.Node.Dir && !watcher.Recursive {
+		return nil, 0, fmt.Errorf("etcd-dir %s is a directory, -recursive=false only watches a single key", *watcher.Env.EtcdDir)
+	}
+
+	data := make(map[string]interface{})
+	if watcher.Env.Base != nil {
+		data = copyData(watcher.Env.Base).(map[string]interface{})
+	}
+	if watcher.Recursive {
+		watcher.Env.BuildData(*response.Node, *watcher.Env.EtcdDir, data)
+	} else {
+		watcher.Env.UpdateData(watcher.keyParts(response.Node.Key), response.Node.Value, "set", data)
+	}
+	return data, response.EtcdIndex, nil
+}
+
+// Compares the data with a fresh Get, replacing it and cycling if they differ.
+// Changes the watch already applied, or is about to, make no difference: they
+// are the same in both.
+func (watcher *Watcher) resync() {
+	data, index, err := watcher.fetch()
+	if err != nil {
+		watcher.Env.Logger.Warnf("[WATCHER] Resync failed: %s", err)
+		return
+	}
+	if index > watcher.index {
+		watcher.index = index
+	}
+
+	if reflect.DeepEqual(data, watcher.Env.Data) {
+		watcher.Env.Logger.Debugf("[WATCHER] Resync found %s in sync", *watcher.Env.EtcdDir)
+		return
+	}
+
+	resyncDrifts.Inc()
+	watcher.Env.Logger.Warnf("[WATCHER] Resync found %s out of sync with etcd, correcting it", *watcher.Env.EtcdDir)
+	watcher.Env.Data = data
+	watcher.Env.ChangedAt = time.Now()
+	watcher.Env.changedKeys = nil
+	watcher.cycle()
+}
+
+// Rebuilds the data from a fresh Get and cycles, reloading even if the
+// configuration didn't change, as asked with Rerender.
+func (watcher *Watcher) rerender() {
+	watcher.Env.Logger.Infof("[WATCHER] Manual re-render of %s requested", *watcher.Env.EtcdDir)
+
+	data, index, err := watcher.fetch()
+	if err != nil {
+		watcher.Env.Logger.Errorf("[WATCHER] Manual re-render failed: %s", err)
+		return
+	}
+	if index > watcher.index {
+		watcher.index = index
+	}
+	watcher.Env.Data = data
+	watcher.Env.ChangedAt = time.Now()
+	watcher.Env.changedKeys = nil
+
+	forceReload := watcher.Env.ForceReload
+	watcher.Env.ForceReload = true
+	ok := watcher.cycle()
+	watcher.Env.ForceReload = forceReload
+	if ok {
+		watcher.Env.Logger.Infof("[WATCHER] Manual re-render of %s done", *watcher.Env.EtcdDir)
+	}
+}
+
+// Watches the etcd directory until stop is closed. Transient etcd
+// errors never make it return: the watcher keeps reconnecting with an
+// exponential backoff, unless MaxReconnectAttempts run out, which is the only
+// error it returns. If the data was never synced (as when starting from the
+// cache) it first connects and resyncs.
+func (watcher *Watcher) Run(stop chan bool) error {
+	if watcher.index == 0 {
+		if ok, err := watcher.reconnect(stop, true); !ok {
+			return err
+		}
+	}
+
+	for {
+		err := watcher.watch(stop)
+		if err == etcd.ErrWatchStoppedByUser {
+			return nil
+		}
+		watcher.Env.Status.SetConnected(false)
+		observeConnected(false)
+
+		resync := etcdErrorCode(err) == etcdErrorIndexCleared
+		if resync {
+			watcher.Env.Logger.Warnf("[WATCHER] Index %d was already cleared by etcd, resyncing", watcher.index+1)
+		} else {
+			watcher.Env.Logger.Warnf("[WATCHER] Watch ended: %v", describeEtcdError(err))
+		}
+
+		if ok, err := watcher.reconnect(stop, resync); !ok {
+			return err
+		}
+	}
+}
+
+// Watches for changes, applying them until the watch ends. Changes update the
+// data right away, but with Debounce a burst of changes is cycled only once.
+// Without it, the changes that arrived in the EventBuffer while cycling are
+// all applied before cycling again, once. Once stop is closed no more changes
+// are applied, but a cycle already running is always finished.
+func (watcher *Watcher) watch(stop chan bool) error {
+	receiver := make(chan *etcd.Response, watcher.EventBuffer)
+	result := make(chan error, 1)
+
+	go func() {
+		_, err := watcher.Client.Watch(*watcher.Env.EtcdDir, watcher.index+1, watcher.Recursive, receiver, stop)
+		result <- err
+	}()
+
+	var quiet, deadline <-chan time.Time
+	pending := false
+
+	var resync <-chan time.Time
+	if watcher.ResyncInterval > 0 {
+		ticker := time.NewTicker(watcher.ResyncInterval)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+	heartbeat, stopHeartbeat := watcher.heartbeat()
+	defer stopHeartbeat()
+
+	for {
+		select {
+		case <-stop:
+			return etcd.ErrWatchStoppedByUser
+		case <-heartbeat:
+			watcher.Heartbeat.Beat()
+			continue
+		case <-resync:
+			watcher.resync()
+			continue
+		case <-watcher.Rerender:
+			watcher.rerender()
+			continue
+		case response, ok := <-receiver:
+			if stopping(stop) {
+				return etcd.ErrWatchStoppedByUser
+			}
+			if !ok {
+				if pending {
+					watcher.cycle()
+				}
+				return <-result
+			}
+
+			changed := watcher.apply(response)
+			if watcher.Debounce <= 0 {
+				if watcher.drain(receiver) || changed {
+					watcher.cycle()
+				} else {
+					watcher.Env.Logger.Debugf("[WATCHER] The data didn't change, skipping the cycle")
+				}
+				continue
+			}
+			if !changed {
+				continue
+			}
+
+			quiet = time.After(watcher.Debounce)
+			if !pending && watcher.DebounceMax > 0 {
+				deadline = time.After(watcher.DebounceMax)
+			}
+			pending = true
+			continue
+		case <-quiet:
+		case <-deadline:
+		}
+
+		watcher.cycle()
+		quiet, deadline, pending = nil, nil, false
+	}
+}
+
+// Applies the changes already waiting in receiver, reporting whether any
+// changed the data. A buffer filling up means cycling is slower than etcd
+// changes, which is logged.
+func (watcher *Watcher) drain(receiver chan *etcd.Response) bool {
+	waiting := len(receiver)
+	if waiting > 0 && waiting >= watcher.EventBuffer/2 {
+		watcher.Env.Logger.Warnf("[WATCHER] %d etcd events were waiting (buffer of %d), rendering or reloading may be too slow", waiting, watcher.EventBuffer)
+	}
+
+	changed := false
+	for ; waiting > 0; waiting-- {
+		if watcher.apply(<-receiver) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Reconnects to the etcd cluster until it succeeds, waiting longer after each
+// failure. With resync the data is rebuilt from scratch and cycled. Returns
+// false if asked to stop in the meantime, or with an error once
+// MaxReconnectAttempts failed. Each call starts over from MinBackoff.
+func (watcher *Watcher) reconnect(stop chan bool, resync bool) (bool, error) {
+	backoff := watcher.MinBackoff
+
+	for attempt := 1; ; attempt++ {
+		watcher.Env.Logger.Infof("[WATCHER] Reconnecting to etcd in %s (attempt %d)", backoff, attempt)
+		if !watcher.sleep(backoff, stop) {
+			return false, nil
+		}
+		observeReconnectAttempt(attempt)
+
+		var err error
+		if resync {
+			err = watcher.Sync()
+		} else if !watcher.Client.SyncCluster() {
+			err = fmt.Errorf("cannot sync with etcd machines")
+		}
+
+		if err == nil {
+			watcher.Env.Status.SetConnected(true)
+			observeConnected(true)
+			watcher.Env.Logger.Infof("[WATCHER] Reconnected to etcd, resuming watch @ %s from index %d", *watcher.Env.EtcdDir, watcher.index+1)
+			observeReconnectAttempt(0)
+			if resync {
+				watcher.cycle()
+			}
+			return true, nil
+		}
+		watcher.Env.Logger.Warnf("[WATCHER] Reconnect failed: %s", err)
+		if watcher.MaxReconnectAttempts > 0 && attempt >= watcher.MaxReconnectAttempts {
+			return false, fmt.Errorf("giving up on etcd after %d reconnect attempts: %s", attempt, err)
+		}
+
+		backoff *= 2
+		if backoff > watcher.MaxBackoff {
+			backoff = watcher.MaxBackoff
+		}
+	}
+}
+
+// Ticks every HeartbeatInterval, or never without a Heartbeat. The returned
+// function stops the ticker.
+func (watcher *Watcher) heartbeat() (<-chan time.Time, func()) {
+	if watcher.Heartbeat == nil || watcher.HeartbeatInterval <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(watcher.HeartbeatInterval)
+	return ticker.C, ticker.Stop
+}
+
+// Waits for delay, beating the Heartbeat meanwhile. Returns false if asked to
+// stop in the meantime.
+func (watcher *Watcher) sleep(delay time.Duration, stop chan bool) bool {
+	heartbeat, stopHeartbeat := watcher.heartbeat()
+	defer stopHeartbeat()
+
+	timer := time.After(delay)
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-heartbeat:
+			watcher.Heartbeat.Beat()
+		case <-timer:
+			return true
+		}
+	}
+}
+
+// Splits a key from etcd into the parts of its path in the data. Without
+// Recursive that's just the name of the key.
+func (watcher *Watcher) keyParts(key string) []string {
+	if !watcher.Recursive {
+		return []string{watcher.Env.decodeSegment(path.Base("/" + cleanKey(key)))}
+	}
+	return watcher.Env.KeyParts(key, *watcher.Env.EtcdDir)
+}
+
+// Applies a change from etcd to the data, reporting whether it changed.
+func (watcher *Watcher) apply(response *etcd.Response) bool {
+	env := watcher.Env
+	watcher.index = response.Node.ModifiedIndex
+	env.ChangedAt = time.Now()
+	env.Status.AddEvent()
+	etcdEvents.Inc()
+
+	if env.outsideDir(response.Node.Key, *env.EtcdDir) {
+		env.Logger.Errorf("[WATCHER] Ignoring %s on %s, it isn't under %s", response.Action, response.Node.Key, *env.EtcdDir)
+		return false
+	}
+	parts := watcher.keyParts(response.Node.Key)
+	if len(parts) == 0 {
+		env.Logger.Debugf("[WATCHER] Ignoring %s on %s itself", response.Action, *env.EtcdDir)
+		return false
+	}
+	if watcher.Recursive && env.StripLevels > 0 {
+		if parts = env.stripLevels(parts); parts == nil {
+			env.Logger.Warnf("[WATCHER] Ignoring %s on %s, it's less than -strip-levels %d deep", response.Action, response.Node.Key, env.StripLevels)
+			return false
+		}
+	}
+	key := strings.Join(parts, "/")
+	var old interface{}
+	if env.Audit != nil {
+		old = lookupData(env.Data, env.dataKey(parts))
+	}
+	var changed bool
+	if response.Node.Dir && !removalAction(response.Action) {
+		changed = env.UpdateDir(parts, env.Data)
+	} else {
+		changed = env.UpdateData(parts, response.Node.Value, response.Action, env.Data)
+	}
+	if changed {
+		env.recordChange(parts, response.Node.Value, response.Action)
+	}
+	rejected := storeAction(response.Action) && env.tooLarge(response.Node.Value)
+	if env.Audit != nil && !env.filtered(parts) && !rejected {
+		env.audit(parts, response.Action, old, response.Node.Value, response.Node.ModifiedIndex)
+	}
+
+	value := env.maskValue(parts, response.Node.Value)
+	env.Logger.Log(LevelDebug, Fields{"action": response.Action, "key": key, "value": value},
+		"[CHANGE]: %s %s %s", response.Action, key, value)
+	return changed
+}
+
+// Cycles the Env, logging why it failed. Reports whether it succeeded.
+func (watcher *Watcher) cycle() (ok bool) {
+	defer watcher.Heartbeat.Beat()
+	defer recoverCycle(watcher.Env, watcher.Env.Status.SetRender)
+
+	if err := watcher.Env.Cycle(); err != nil {
+		watcher.Env.Logger.Errorf("[ENV] %s", err)
+		return false
+	}
+	return true
+}
+
+// Logs a panic of a renderer or reloader, so it fails that cycle instead of
+// the whole daemon, and records it with record. To be deferred.
+func recoverCycle(env *Env, record func(error)) {
+	if r := recover(); r != nil {
+		err := fmt.Errorf("panic: %v", r)
+		record(err)
+		env.Logger.Errorf("[ENV] Cycle failed with a %s\n%s", err, debug.Stack())
+	}
+}
+
+// Reports whether stop was closed, without waiting.
+func stopping(stop chan bool) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,466 @@
+package src
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// A renderer that panics.
+type PanickingRenderer struct {
+	Calls int
+}
+
+func (r *PanickingRenderer) Render(env Env) ([]byte, error) {
+	r.Calls++
+	panic("boom")
+}
+func (r *PanickingRenderer) File() string {
+	return "-"
+}
+func (r *PanickingRenderer) RegisterFlags() {
+}
+
+// A fake etcd client. Get returns the next of its responses, Watch sends the
+// next batch of events and then fails with the next error.
+type MockEtcdClient struct {
+	// SyncCluster fails this many times first
+	Unreachable  int
+	Gets         []*etcd.Response
+	Events       [][]*etcd.Response
+	WatchErrors  []error
+	GetCalls     int
+	WatchIndexes []uint64
+}
+
+func (c *MockEtcdClient) SyncCluster() bool {
+	c.Unreachable--
+	return c.Unreachable < 0
+}
+
+func (c *MockEtcdClient) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	response := c.Gets[c.GetCalls]
+	c.GetCalls++
+	return response, nil
+}
+
+func (c *MockEtcdClient) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	defer close(receiver)
+
+	call := len(c.WatchIndexes)
+	c.WatchIndexes = append(c.WatchIndexes, waitIndex)
+
+	if call < len(c.Events) {
+		for _, response := range c.Events[call] {
+			receiver <- response
+		}
+	}
+	if call < len(c.WatchErrors) {
+		return nil, c.WatchErrors[call]
+	}
+	return nil, etcd.ErrWatchStoppedByUser
+}
+
+func dirResponse(index uint64, nodes ...*etcd.Node) *etcd.Response {
+	return &etcd.Response{Action: "get", EtcdIndex: index, Node: &etcd.Node{Key: "/rails", Dir: true, Nodes: nodes}}
+}
+
+func newTestWatcher(client *MockEtcdClient) *Watcher {
+	dir := "/rails"
+	env := &Env{EtcdDir: &dir, Renderer: new(MockRenderer), Reloader: new(MockReloader)}
+
+	watcher := NewWatcher(client, env)
+	watcher.MinBackoff = time.Millisecond
+	return watcher
+}
+
+func TestWatcherReconnects(t *testing.T) {
+	client := &MockEtcdClient{
+		Gets: []*etcd.Response{
+			dirResponse(10, &etcd.Node{Key: "/rails/hostname", Value: "localhost"}),
+		},
+		Events: [][]*etcd.Response{
+			{{Action: "set", Node: &etcd.Node{Key: "/rails/port", Value: "5432", ModifiedIndex: 12}}},
+		},
+		WatchErrors: []error{errors.New("connection reset")},
+	}
+	watcher := newTestWatcher(client)
+
+	assert.Equal(t, watcher.Sync(), nil)
+	assert.Equal(t, watcher.Env.Data["hostname"], "localhost")
+
+	watcher.Run(make(chan bool))
+
+	// the watch resumes after the last event, without a new Get
+	assert.Equal(t, client.GetCalls, 1)
+	assert.Equal(t, client.WatchIndexes, []uint64{11, 13})
+	assert.Equal(t, watcher.Env.Data["port"], "5432")
+}
+
+func TestWatcherSkipsUnchanged(t *testing.T) {
+	client := &MockEtcdClient{
+		Gets: []*etcd.Response{
+			dirResponse(10, &etcd.Node{Key: "/rails/hostname", Value: "localhost"}),
+		},
+		Events: [][]*etcd.Response{
+			{{Action: "set", Node: &etcd.Node{Key: "/rails/hostname", Value: "localhost", ModifiedIndex: 11}}},
+		},
+	}
+	watcher := newTestWatcher(client)
+
+	assert.Equal(t, watcher.Sync(), nil)
+	watcher.Run(make(chan bool))
+
+	// the replayed value isn't rendered again
+	assert.Equal(t, watcher.index, uint64(11))
+	assert.Equal(t, watcher.Env.Renderer.(*MockRenderer).Calls, 0)
+}
+
+func TestWatcherMaxReconnectAttempts(t *testing.T) {
+	client := &MockEtcdClient{
+		Gets:        []*etcd.Response{dirResponse(10)},
+		WatchErrors: []error{errors.New("connection reset")},
+	}
+	watcher := newTestWatcher(client)
+	watcher.MaxReconnectAttempts = 3
+	assert.Equal(t, watcher.Sync(), nil)
+
+	// reconnected on the third attempt
+	client.Unreachable = 2
+	assert.Equal(t, watcher.Run(make(chan bool)), nil)
+	assert.Equal(t, client.WatchIndexes, []uint64{11, 11})
+
+	client.WatchIndexes = nil
+	client.Unreachable = 5
+	err := watcher.Run(make(chan bool))
+	assert.Equal(t, err.Error(), "giving up on etcd after 3 reconnect attempts: cannot sync with etcd machines")
+	assert.Equal(t, client.Unreachable, 2)
+}
+
+func TestWatcherHeartbeat(t *testing.T) {
+	watcher := newTestWatcher(new(MockEtcdClient))
+	watcher.Heartbeat = &Heartbeat{last: time.Now().Add(-time.Minute)}
+	watcher.HeartbeatInterval = 10 * time.Millisecond
+
+	// waiting to reconnect is progress too
+	assert.Equal(t, watcher.sleep(50*time.Millisecond, make(chan bool)), true)
+	assert.T(t, watcher.Heartbeat.Since() < 50*time.Millisecond)
+}
+
+func TestWatcherIndexCleared(t *testing.T) {
+	client := &MockEtcdClient{
+		Gets: []*etcd.Response{
+			dirResponse(10, &etcd.Node{Key: "/rails/hostname", Value: "localhost"}),
+			dirResponse(2000, &etcd.Node{Key: "/rails/hostname", Value: "db01"}),
+		},
+		WatchErrors: []error{etcd.EtcdError{ErrorCode: 401, Message: "The event in requested index is outdated and cleared"}},
+	}
+	watcher := newTestWatcher(client)
+
+	assert.Equal(t, watcher.Sync(), nil)
+	watcher.Run(make(chan bool))
+
+	// the data is rebuilt and the watch resumes from the new index
+	assert.Equal(t, client.GetCalls, 2)
+	assert.Equal(t, client.WatchIndexes, []uint64{11, 2001})
+	assert.Equal(t, watcher.Env.Data["hostname"], "db01")
+	assert.Equal(t, watcher.Env.Renderer.(*MockRenderer).Called, true)
+}
+
+func TestWatcherDebounce(t *testing.T) {
+	events := []*etcd.Response{
+		{Action: "set", Node: &etcd.Node{Key: "/rails/a", Value: "1", ModifiedIndex: 11}},
+		{Action: "set", Node: &etcd.Node{Key: "/rails/b", Value: "2", ModifiedIndex: 12}},
+		{Action: "set", Node: &etcd.Node{Key: "/rails/c", Value: "3", ModifiedIndex: 13}},
+	}
+
+	client := &MockEtcdClient{Gets: []*etcd.Response{dirResponse(10)}, Events: [][]*etcd.Response{events}}
+	watcher := newTestWatcher(client)
+	assert.Equal(t, watcher.Sync(), nil)
+	watcher.Run(make(chan bool))
+	assert.Equal(t, watcher.Env.Renderer.(*MockRenderer).Calls, 3)
+
+	client = &MockEtcdClient{Gets: []*etcd.Response{dirResponse(10)}, Events: [][]*etcd.Response{events}}
+	watcher = newTestWatcher(client)
+	watcher.Debounce = time.Second
+	assert.Equal(t, watcher.Sync(), nil)
+	watcher.Run(make(chan bool))
+	assert.Equal(t, watcher.Env.Renderer.(*MockRenderer).Calls, 1)
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{"a": "1", "b": "2", "c": "3"})
+}
+
+// A reloader remembering the keys each reload was for.
+type KeysReloader struct {
+	Reloads [][]string
+	// Fail the first Failures reloads
+	Failures int
+}
+
+func (r *KeysReloader) Reload(env Env) error {
+	r.Reloads = append(r.Reloads, env.ChangedKeys)
+	if r.Failures > 0 {
+		r.Failures--
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func (r *KeysReloader) RegisterFlags() {
+}
+
+func TestWatcherChangedKeys(t *testing.T) {
+	events := []*etcd.Response{
+		{Action: "set", Node: &etcd.Node{Key: "/rails/database/host", Value: "db02", ModifiedIndex: 11}},
+		{Action: "set", Node: &etcd.Node{Key: "/rails/pool", Value: "10", ModifiedIndex: 12}},
+		{Action: "set", Node: &etcd.Node{Key: "/rails/database/host", Value: "db03", ModifiedIndex: 13}},
+		// changes nothing
+		{Action: "delete", Node: &etcd.Node{Key: "/rails/missing", ModifiedIndex: 14}},
+	}
+	client := &MockEtcdClient{
+		Gets:   []*etcd.Response{dirResponse(10, &etcd.Node{Key: "/rails/pool", Value: "5"})},
+		Events: [][]*etcd.Response{events},
+	}
+	watcher := newTestWatcher(client)
+	reloader := new(KeysReloader)
+	watcher.Env.Reloader = reloader
+	watcher.Debounce = time.Second
+
+	assert.Equal(t, watcher.Sync(), nil)
+	assert.Equal(t, watcher.Env.Cycle(), nil)
+	watcher.Run(make(chan bool))
+
+	// the initial reload doesn't know them, then a reload for the burst
+	assert.Equal(t, reloader.Reloads, [][]string{nil, {"database/host", "pool"}})
+	assert.Equal(t, len(watcher.Env.changedKeys), 0)
+	assert.Equal(t, watcher.Env.ChangedKeys, []string(nil))
+}
+
+func TestWatcherStops(t *testing.T) {
+	client := &MockEtcdClient{
+		Gets: []*etcd.Response{dirResponse(10)},
+		Events: [][]*etcd.Response{
+			{{Action: "set", Node: &etcd.Node{Key: "/rails/port", Value: "5432", ModifiedIndex: 12}}},
+		},
+	}
+	watcher := newTestWatcher(client)
+	assert.Equal(t, watcher.Sync(), nil)
+
+	stop := make(chan bool)
+	close(stop)
+	watcher.Run(stop)
+
+	// changes arriving after the stop are ignored
+	assert.Equal(t, watcher.Env.Data["port"], nil)
+	assert.Equal(t, watcher.Env.Renderer.(*MockRenderer).Calls, 0)
+}
+
+func TestWatcherResync(t *testing.T) {
+	client := &MockEtcdClient{
+		Gets: []*etcd.Response{
+			dirResponse(10, &etcd.Node{Key: "/rails/hostname", Value: "localhost"}),
+			dirResponse(15, &etcd.Node{Key: "/rails/hostname", Value: "localhost"}),
+			dirResponse(20, &etcd.Node{Key: "/rails/hostname", Value: "db01"}),
+		},
+	}
+	watcher := newTestWatcher(client)
+	assert.Equal(t, watcher.Sync(), nil)
+	renderer := watcher.Env.Renderer.(*MockRenderer)
+
+	// nothing drifted
+	watcher.resync()
+	assert.Equal(t, renderer.Calls, 0)
+	assert.Equal(t, watcher.index, uint64(15))
+
+	// a missed change is corrected
+	watcher.resync()
+	assert.Equal(t, watcher.Env.Data["hostname"], "db01")
+	assert.Equal(t, renderer.Calls, 1)
+	assert.Equal(t, watcher.index, uint64(20))
+}
+
+func TestWatcherRerender(t *testing.T) {
+	client := &MockEtcdClient{
+		Gets: []*etcd.Response{
+			dirResponse(10, &etcd.Node{Key: "/rails/hostname", Value: "localhost"}),
+			dirResponse(15, &etcd.Node{Key: "/rails/hostname", Value: "localhost"}),
+		},
+	}
+	watcher := newTestWatcher(client)
+	assert.Equal(t, watcher.Sync(), nil)
+	renderer := watcher.Env.Renderer.(*MockRenderer)
+	reloader := watcher.Env.Reloader.(*MockReloader)
+	renderer.Out = []byte("hostname: localhost\n")
+	assert.Equal(t, watcher.Env.Cycle(), nil)
+	reloader.Called = false
+
+	// nothing changed, but it's rendered and reloaded anyway
+	watcher.rerender()
+	assert.Equal(t, client.GetCalls, 2)
+	assert.Equal(t, renderer.Calls, 2)
+	assert.Equal(t, reloader.Called, true)
+	assert.Equal(t, watcher.index, uint64(15))
+	assert.Equal(t, watcher.Env.ForceReload, false)
+}
+
+func TestWatcherDrain(t *testing.T) {
+	watcher := newTestWatcher(&MockEtcdClient{})
+	watcher.Env.Data = map[string]interface{}{}
+	watcher.EventBuffer = 10
+
+	receiver := make(chan *etcd.Response, watcher.EventBuffer)
+	receiver <- &etcd.Response{Action: "set", Node: &etcd.Node{Key: "/rails/hostname", Value: "db01", ModifiedIndex: 11}}
+	receiver <- &etcd.Response{Action: "set", Node: &etcd.Node{Key: "/rails/port", Value: "5432", ModifiedIndex: 12}}
+	watcher.drain(receiver)
+
+	// the waiting events are all applied, without cycling
+	assert.Equal(t, len(receiver), 0)
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{"hostname": "db01", "port": "5432"})
+	assert.Equal(t, watcher.index, uint64(12))
+	assert.Equal(t, watcher.Env.Renderer.(*MockRenderer).Calls, 0)
+}
+
+func TestWatcherDirectoryEvent(t *testing.T) {
+	watcher := newTestWatcher(&MockEtcdClient{})
+	watcher.Env.Data = map[string]interface{}{"database": map[string]interface{}{"host": "db01"}}
+
+	// a directory that's set again keeps its keys
+	watcher.apply(&etcd.Response{Action: "set", Node: &etcd.Node{Key: "/rails/database", Dir: true, ModifiedIndex: 11}})
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{"database": map[string]interface{}{"host": "db01"}})
+
+	watcher.apply(&etcd.Response{Action: "delete", Node: &etcd.Node{Key: "/rails/database", Dir: true, ModifiedIndex: 12}})
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{})
+}
+
+func TestWatcherStrictDir(t *testing.T) {
+	watcher := newTestWatcher(&MockEtcdClient{})
+	watcher.Env.Data = map[string]interface{}{}
+
+	// without StrictDir the key is used whole
+	watcher.apply(&etcd.Response{Action: "set", Node: &etcd.Node{Key: "/other/hostname", Value: "db01", ModifiedIndex: 11}})
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{"other": map[string]interface{}{"hostname": "db01"}})
+
+	watcher.Env.Data = map[string]interface{}{}
+	watcher.Env.StrictDir = true
+	watcher.apply(&etcd.Response{Action: "set", Node: &etcd.Node{Key: "/other/hostname", Value: "db01", ModifiedIndex: 12}})
+	watcher.apply(&etcd.Response{Action: "set", Node: &etcd.Node{Key: "/railsapp/hostname", Value: "db01", ModifiedIndex: 13}})
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{})
+
+	watcher.apply(&etcd.Response{Action: "set", Node: &etcd.Node{Key: "/rails/hostname", Value: "db01", ModifiedIndex: 14}})
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{"hostname": "db01"})
+}
+
+func TestWatcherStripLevels(t *testing.T) {
+	host := map[string]interface{}{"host": "db01"}
+	tests := []struct {
+		levels int
+		data   map[string]interface{}
+	}{
+		{0, map[string]interface{}{"team": map[string]interface{}{"owner": "ops", "app": map[string]interface{}{"database": host}}}},
+		{1, map[string]interface{}{"owner": "ops", "app": map[string]interface{}{"database": host}}},
+		// team/owner is too shallow
+		{2, map[string]interface{}{"database": host}},
+	}
+
+	for _, test := range tests {
+		watcher := newTestWatcher(&MockEtcdClient{})
+		watcher.Env.Data = map[string]interface{}{}
+		watcher.Env.StripLevels = test.levels
+
+		watcher.apply(&etcd.Response{Action: "set", Node: &etcd.Node{Key: "/rails/team/app/database/host", Value: "db01", ModifiedIndex: 11}})
+		watcher.apply(&etcd.Response{Action: "set", Node: &etcd.Node{Key: "/rails/team/owner", Value: "ops", ModifiedIndex: 12}})
+		assert.Equal(t, watcher.Env.Data, test.data)
+	}
+}
+
+func TestWatcherSingleKey(t *testing.T) {
+	key := &etcd.Response{Action: "get", EtcdIndex: 10, Node: &etcd.Node{Key: "/rails/secret_key_base", Value: "abc"}}
+	client := &MockEtcdClient{
+		Gets: []*etcd.Response{key, key},
+		Events: [][]*etcd.Response{{
+			{Action: "set", Node: &etcd.Node{Key: "/rails/secret_key_base", Value: "def", ModifiedIndex: 11}},
+		}},
+	}
+	watcher := newTestWatcher(client)
+	watcher.Recursive = false
+	dir := "/rails/secret_key_base"
+	watcher.Env.EtcdDir = &dir
+
+	assert.Equal(t, watcher.Sync(), nil)
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{"secret_key_base": "abc"})
+
+	watcher.Run(make(chan bool))
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{"secret_key_base": "def"})
+
+	// a key can't be watched as a directory
+	watcher.Recursive = true
+	assert.Equal(t, watcher.Sync().Error(), "etcd-dir /rails/secret_key_base is a key, pass -recursive=false to watch a single key")
+}
+
+func TestWatcherSyncRetrying(t *testing.T) {
+	client := &MockEtcdClient{Unreachable: 2, Gets: []*etcd.Response{dirResponse(10)}}
+	watcher := newTestWatcher(client)
+
+	assert.NotEqual(t, watcher.SyncRetrying(1, 0), nil)
+	client.Unreachable = 2
+	assert.Equal(t, watcher.SyncRetrying(2, 0), nil)
+	assert.Equal(t, client.GetCalls, 1)
+}
+
+// An etcd client whose Get never answers, until released.
+type HangingEtcdClient struct {
+	MockEtcdClient
+	release chan bool
+}
+
+func (c *HangingEtcdClient) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	<-c.release
+	return c.MockEtcdClient.Get(key, sort, recursive)
+}
+
+func TestWatcherSyncTimeout(t *testing.T) {
+	client := &HangingEtcdClient{MockEtcdClient{Gets: []*etcd.Response{dirResponse(10, &etcd.Node{Key: "/rails/hostname", Value: "db01"})}}, make(chan bool)}
+	watcher := newTestWatcher(&client.MockEtcdClient)
+	watcher.Client = client
+	watcher.Env.Data = map[string]interface{}{"hostname": "cached"}
+
+	err := watcher.SyncRetrying(3, 10*time.Millisecond)
+	assert.Equal(t, err.Error(), "etcd didn't answer within the -startup-timeout of 10ms")
+
+	// a late answer is dropped
+	close(client.release)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{"hostname": "cached"})
+	assert.Equal(t, watcher.index, uint64(0))
+}
+
+func TestWatcherSurvivesFailedCycles(t *testing.T) {
+	events := []*etcd.Response{
+		{Action: "set", Node: &etcd.Node{Key: "/rails/a", Value: "1", ModifiedIndex: 11}},
+		{Action: "set", Node: &etcd.Node{Key: "/rails/b", Value: "2", ModifiedIndex: 12}},
+	}
+
+	// a render error
+	renderer := &MockRenderer{Err: errors.New("broken template")}
+	client := &MockEtcdClient{Gets: []*etcd.Response{dirResponse(10)}, Events: [][]*etcd.Response{events}}
+	watcher := newTestWatcher(client)
+	watcher.Env.Renderer = renderer
+	assert.Equal(t, watcher.Sync(), nil)
+	watcher.Run(make(chan bool))
+	assert.Equal(t, renderer.Calls, 2)
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{"a": "1", "b": "2"})
+
+	// a panic
+	panicking := new(PanickingRenderer)
+	client = &MockEtcdClient{Gets: []*etcd.Response{dirResponse(10)}, Events: [][]*etcd.Response{events}}
+	watcher = newTestWatcher(client)
+	watcher.Env.Renderer = panicking
+	watcher.Env.Status = new(Status)
+	assert.Equal(t, watcher.Sync(), nil)
+	watcher.Run(make(chan bool))
+	assert.Equal(t, panicking.Calls, 2)
+	assert.Equal(t, watcher.Env.Data, map[string]interface{}{"a": "1", "b": "2"})
+	assert.Equal(t, *watcher.Env.Status.Report(true).LastRenderError, "panic: boom")
+}
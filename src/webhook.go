@@ -0,0 +1,108 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type WebhookReloader struct {
+	Url     *string
+	Method  *string
+	Headers http.Header
+	Body    *bool
+	Timeout *time.Duration
+}
+
+// Sends a request to -webhook-url. With -webhook-body the request carries a
+// small JSON document describing the change, with the changed keys when
+// they're known. Errors and non 2xx responses fail the reload, so it's retried
+// as -reload-retries says.
+func (reloader *WebhookReloader) Reload(env Env) error {
+	env.Logger.Infof("[WEBHOOK RELOADER] %s %s", *reloader.Method, *reloader.Url)
+
+	var body io.Reader
+	if *reloader.Body {
+		payload := map[string]interface{}{"file": env.OutputFile()}
+		if env.EtcdDir != nil {
+			payload["etcd_dir"] = *env.EtcdDir
+		}
+		if len(env.ChangedKeys) > 0 {
+			payload["changed_keys"] = env.ChangedKeys
+		}
+		out, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(out)
+	}
+
+	request, err := http.NewRequest(*reloader.Method, *reloader.Url, body)
+	if err != nil {
+		return err
+	}
+	for name, values := range reloader.Headers {
+		request.Header[name] = values
+	}
+	if body != nil && request.Header.Get("Content-Type") == "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: *reloader.Timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+func (reloader *WebhookReloader) RegisterFlags() {
+	reloader.Headers = make(http.Header)
+
+	reloader.Url = flag.String("webhook-url", "", "The URL to call when we need to reload")
+	reloader.Method = flag.String("webhook-method", "POST", "The HTTP method of the webhook request")
+	flag.Var(headerFlag(reloader.Headers), "webhook-header", "An extra header for the webhook request, as \"Name: value\" (can be repeated)")
+	reloader.Body = flag.Bool("webhook-body", false, "Send a JSON body describing the change with the webhook request")
+	reloader.Timeout = flag.Duration("webhook-timeout", 10*time.Second, "How long to wait for the webhook to answer")
+}
+
+func (reloader *WebhookReloader) Open() error {
+	if *reloader.Url == "" {
+		return fmt.Errorf("-webhook-url is required")
+	}
+	return nil
+}
+
+// A flag.Value adding "Name: value" headers
+type headerFlag http.Header
+
+func (headers headerFlag) String() string {
+	return ""
+}
+
+func (headers headerFlag) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("expected \"Name: value\", got %q", value)
+	}
+
+	http.Header(headers).Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	return nil
+}
+
+func init() {
+	webhookReloader := WebhookReloader{}
+	RegisterReloader("webhook", &webhookReloader)
+}
@@ -0,0 +1,41 @@
+package src
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestWebhookReload(t *testing.T) {
+	var requests int
+	var body, token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		out, _ := ioutil.ReadAll(r.Body)
+		body, token = string(out), r.Header.Get("X-Token")
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	url, method, withBody, timeout := server.URL, "POST", true, time.Second
+	reloader := WebhookReloader{Url: &url, Method: &method, Body: &withBody, Timeout: &timeout, Headers: http.Header{}}
+	assert.Equal(t, headerFlag(reloader.Headers).Set("X-Token: secret"), nil)
+
+	// a non 2xx answer fails the reload, for -reload-retries to retry it
+	dir := "/rails"
+	assert.Equal(t, reloader.Reload(Env{EtcdDir: &dir}).Error(), "webhook answered 503 Service Unavailable")
+	assert.Equal(t, reloader.Reload(Env{EtcdDir: &dir}), nil)
+	assert.Equal(t, requests, 2)
+	assert.Equal(t, token, "secret")
+	assert.Equal(t, body, `{"etcd_dir":"/rails","file":""}`)
+
+	// with the changed keys when they're known
+	assert.Equal(t, reloader.Reload(Env{EtcdDir: &dir, ChangedKeys: []string{"pool"}}), nil)
+	assert.Equal(t, body, `{"changed_keys":["pool"],"etcd_dir":"/rails","file":""}`)
+}
@@ -0,0 +1,140 @@
+package src
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type XmlRenderer struct {
+	XmlFile *string
+	Root    *string
+}
+
+// Renders the data as an XML document under a -xml-root element. Map keys
+// become elements, sorted to keep the file stable between cycles, and lists
+// repeat the element of their key for each item (items of nested lists are
+// <item> elements). Keys that aren't valid XML names are sanitized by
+// xmlName.
+func (renderer *XmlRenderer) Render(env Env) ([]byte, error) {
+	path := env.outputPath(renderer.File())
+	env.Logger.Infof("[XML RENDERER] Rendering to %s", path)
+
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	if err := writeXml(&out, xmlName(*renderer.Root), env.Data, ""); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+func writeXml(out *bytes.Buffer, name string, value interface{}, indent string) error {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			fmt.Fprintf(out, "%s<%s/>\n", indent, name)
+			return nil
+		}
+
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(out, "%s<%s>\n", indent, name)
+		for _, key := range keys {
+			child := value[key]
+			if list, ok := child.([]interface{}); ok {
+				for _, item := range list {
+					if err := writeXmlItem(out, xmlName(key), item, indent+"  "); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := writeXml(out, xmlName(key), child, indent+"  "); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(out, "%s</%s>\n", indent, name)
+	case nil:
+		fmt.Fprintf(out, "%s<%s/>\n", indent, name)
+	default:
+		fmt.Fprintf(out, "%s<%s>", indent, name)
+		if err := xml.EscapeText(out, []byte(fmt.Sprint(value))); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "</%s>\n", name)
+	}
+	return nil
+}
+
+// Writes an item of a list. Nested lists have no key to repeat, so their
+// items are <item> elements.
+func writeXmlItem(out *bytes.Buffer, name string, item interface{}, indent string) error {
+	list, ok := item.([]interface{})
+	if !ok {
+		return writeXml(out, name, item, indent)
+	}
+
+	fmt.Fprintf(out, "%s<%s>\n", indent, name)
+	for _, child := range list {
+		if err := writeXmlItem(out, "item", child, indent+"  "); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(out, "%s</%s>\n", indent, name)
+	return nil
+}
+
+// Turns a key into a valid XML name: characters that can't be in a name
+// become underscores, and names that can't start with their first character
+// (or that start with the reserved "xml") get an underscore in front.
+func xmlName(key string) string {
+	var name bytes.Buffer
+	for _, r := range key {
+		if xmlNameChar(r) {
+			name.WriteRune(r)
+		} else {
+			name.WriteByte('_')
+		}
+	}
+
+	sanitized := name.String()
+	if sanitized == "" || !xmlNameStart([]rune(sanitized)[0]) || strings.HasPrefix(strings.ToLower(sanitized), "xml") {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
+
+func xmlNameStart(r rune) bool {
+	return r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= 0xC0 && r <= 0xD6) ||
+		(r >= 0xD8 && r <= 0xF6) || (r >= 0xF8 && r <= 0x2FF) || (r >= 0x370 && r <= 0x37D) ||
+		(r >= 0x37F && r <= 0x1FFF) || (r >= 0x200C && r <= 0x200D) || (r >= 0x2070 && r <= 0x218F) ||
+		(r >= 0x2C00 && r <= 0x2FEF) || (r >= 0x3001 && r <= 0xD7FF) || (r >= 0xF900 && r <= 0xFDCF) ||
+		(r >= 0xFDF0 && r <= 0xFFFD) || (r >= 0x10000 && r <= 0xEFFFF)
+}
+
+func xmlNameChar(r rune) bool {
+	return xmlNameStart(r) || r == '-' || r == '.' || (r >= '0' && r <= '9') || r == 0xB7 ||
+		(r >= 0x300 && r <= 0x36F) || (r >= 0x203F && r <= 0x2040)
+}
+
+func (renderer *XmlRenderer) File() string {
+	return *renderer.XmlFile
+}
+
+func (renderer *XmlRenderer) RegisterFlags() {
+	renderer.XmlFile = flag.String("xml-file", "config/config.xml", "The output of the XML file")
+	renderer.Root = flag.String("xml-root", "config", "The name of the root element of the XML file")
+}
+
+func init() {
+	xmlRenderer := XmlRenderer{}
+	RegisterRenderer("xml", &xmlRenderer)
+}
@@ -0,0 +1,64 @@
+package src
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestXmlRender(t *testing.T) {
+	file := "config.xml"
+	root := "settings"
+	renderer := XmlRenderer{XmlFile: &file, Root: &root}
+
+	data := map[string]interface{}{
+		"database": map[string]interface{}{"pool": int64(5), "url": "postgres://db01/app?ssl=true&timeout=5"},
+		"servers":  []interface{}{"web01", "web02"},
+		"matrix":   []interface{}{[]interface{}{"a", "b"}},
+		"greeting": `<say "hi">`,
+		"empty":    map[string]interface{}{},
+	}
+	out, err := renderer.Render(Env{Data: data})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `<?xml version="1.0" encoding="UTF-8"?>
+<settings>
+  <database>
+    <pool>5</pool>
+    <url>postgres://db01/app?ssl=true&amp;timeout=5</url>
+  </database>
+  <empty/>
+  <greeting>&lt;say &#34;hi&#34;&gt;</greeting>
+  <matrix>
+    <item>a</item>
+    <item>b</item>
+  </matrix>
+  <servers>web01</servers>
+  <servers>web02</servers>
+</settings>
+`)
+
+	// the document parses back
+	var parsed struct {
+		Servers []string `xml:"servers"`
+	}
+	assert.Equal(t, xml.Unmarshal(out, &parsed), nil)
+	assert.Equal(t, parsed.Servers, []string{"web01", "web02"})
+}
+
+func TestXmlName(t *testing.T) {
+	for key, name := range map[string]string{
+		"database":     "database",
+		"api-key":      "api-key",
+		"api key":      "api_key",
+		"db:primary":   "db_primary",
+		"2fa":          "_2fa",
+		"-flag":        "_-flag",
+		"xml_settings": "_xml_settings",
+		"café":         "café",
+		"a/b<c>":       "a_b_c_",
+		"":             "_",
+	} {
+		assert.Equal(t, xmlName(key), name)
+	}
+}
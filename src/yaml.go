@@ -1,33 +1,204 @@
 package src
 
 import (
+	"bytes"
 	"flag"
-	"io/ioutil"
-	"log"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 
-	"gopkg.in/v1/yaml"
+	"gopkg.in/yaml.v2"
 )
 
 type YamlRenderer struct {
-	YamlFile *string
+	YamlFile        *string
+	IndentSequences *bool
 }
 
-func (renderer *YamlRenderer) Render(env Env) {
-	log.Printf("[YAML RENDERER] Rendering to %s", *renderer.YamlFile)
+// Renders the data as YAML. Map keys are sorted before marshaling, so the
+// same data always produces a byte-identical file. yaml.v2 always indents
+// with two spaces and never indents sequences under their key, so for any
+// other -indent or -yaml-indent-sequences the document is laid out by
+// yamlLayout instead.
+func (renderer *YamlRenderer) Render(env Env) ([]byte, error) {
+	path := env.outputPath(renderer.File())
+	env.Logger.Infof("[YAML RENDERER] Rendering to %s", path)
 
-	out, err := yaml.Marshal(env.Data)
+	data, err := env.sectionData(path, "YAML", parseYamlSections)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	err = ioutil.WriteFile(*renderer.YamlFile, out, 0644)
+	layout := yamlLayout{indent: env.indent(), sequences: renderer.IndentSequences != nil && *renderer.IndentSequences}
+	if layout.indent < 2 || layout.indent > 9 {
+		return nil, fmt.Errorf("YAML can only be indented by 2 to 9 spaces, not %d", layout.indent)
+	}
+
+	var out []byte
+	if layout.indent == 2 && !layout.sequences {
+		out, err = yaml.Marshal(sortedYaml(data))
+	} else {
+		var buffer bytes.Buffer
+		err = layout.document(&buffer, data)
+		out = buffer.Bytes()
+	}
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+
+	return out, nil
+}
+
+func (renderer *YamlRenderer) File() string {
+	return *renderer.YamlFile
+}
+
+func (renderer *YamlRenderer) Commented() bool {
+	return true
 }
 
 func (renderer *YamlRenderer) RegisterFlags() {
 	renderer.YamlFile = flag.String("yaml-file", "config/config.yml", "The output of the Yaml file")
+	renderer.IndentSequences = flag.Bool("yaml-indent-sequences", false, "Indent sequences under their key, instead of starting their items at the key's column")
+}
+
+// Recursively turns maps into yaml.MapSlice values with sorted keys, since
+// yaml.MapSlice is the only way to control the order of the emitted keys.
+func sortedYaml(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		slice := make(yaml.MapSlice, 0, len(keys))
+		for _, key := range keys {
+			slice = append(slice, yaml.MapItem{Key: key, Value: sortedYaml(value[key])})
+		}
+		return slice
+	case []interface{}:
+		slice := make([]interface{}, len(value))
+		for i, child := range value {
+			slice[i] = sortedYaml(child)
+		}
+		return slice
+	default:
+		return value
+	}
+}
+
+// Writes YAML documents with indent spaces per level, sorting map keys and
+// with sequences indented under their key or not. Only scalars are marshaled
+// by yaml.v2, so they're quoted and escaped just the same.
+type yamlLayout struct {
+	indent    int
+	sequences bool
+}
+
+func (layout yamlLayout) document(out *bytes.Buffer, data map[string]interface{}) error {
+	if len(data) == 0 {
+		out.WriteString("{}\n")
+		return nil
+	}
+	return layout.node(out, data, 0)
+}
+
+// Writes a non empty map or list whose lines start at column.
+func (layout yamlLayout) node(out *bytes.Buffer, value interface{}, column int) error {
+	pad := strings.Repeat(" ", column)
+	dash := pad + "-" + strings.Repeat(" ", layout.indent-1)
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			name, err := layout.scalar(key, column)
+			if err != nil {
+				return err
+			}
+			out.WriteString(pad + name + ":")
+
+			child := value[key]
+			if !yamlBlock(child) {
+				text, err := layout.scalar(child, column+layout.indent)
+				if err != nil {
+					return err
+				}
+				out.WriteString(" " + text + "\n")
+				continue
+			}
+
+			out.WriteString("\n")
+			childColumn := column + layout.indent
+			if _, ok := child.([]interface{}); ok && !layout.sequences {
+				childColumn = column
+			}
+			if err := layout.node(out, child, childColumn); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			if !yamlBlock(child) {
+				text, err := layout.scalar(child, column+layout.indent)
+				if err != nil {
+					return err
+				}
+				out.WriteString(dash + text + "\n")
+				continue
+			}
+
+			// the item starts on the dash's line
+			var item bytes.Buffer
+			if err := layout.node(&item, child, column+layout.indent); err != nil {
+				return err
+			}
+			out.WriteString(dash)
+			out.Write(item.Bytes()[column+layout.indent:])
+		}
+	}
+	return nil
+}
+
+// Block scalar headers with an indentation indicator, like |2-
+var yamlIndicator = regexp.MustCompile(`^([|>])[1-9]([-+]?)$`)
+
+// Marshals a scalar (or an empty map or list). Scalars spanning several lines,
+// like literal blocks, have their other lines moved from yaml.v2's two spaces
+// to column.
+func (layout yamlLayout) scalar(value interface{}, column int) (string, error) {
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	lines[0] = yamlIndicator.ReplaceAllString(lines[0], fmt.Sprintf("${1}%d${2}", layout.indent))
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = strings.Repeat(" ", column) + strings.TrimPrefix(lines[i], "  ")
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Reports whether value is written as a block: a non empty map or list.
+func yamlBlock(value interface{}) bool {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return len(value) > 0
+	case []interface{}:
+		return len(value) > 0
+	}
+	return false
 }
 
 func init() {
@@ -0,0 +1,85 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestYamlRenderDeterministic(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rails-configd")
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yml")
+	renderer := YamlRenderer{YamlFile: &file}
+
+	data := map[string]interface{}{}
+	for _, key := range []string{"zeta", "alpha", "mongodb", "beta", "gamma", "delta"} {
+		data[key] = map[string]interface{}{"hostname": "localhost", "port": "27017", "database": key}
+	}
+	env := Env{Data: data, Renderer: &renderer}
+
+	changed, err := env.render()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, true)
+	first, _ := ioutil.ReadFile(file)
+
+	changed, err = env.render()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, changed, false)
+	second, _ := ioutil.ReadFile(file)
+
+	assert.Equal(t, string(first), string(second))
+}
+
+func TestYamlRenderIndent(t *testing.T) {
+	file := "config.yml"
+	indentSequences := false
+	renderer := YamlRenderer{YamlFile: &file, IndentSequences: &indentSequences}
+
+	data := map[string]interface{}{
+		"production": map[string]interface{}{
+			"adapter":  "postgresql",
+			"replicas": []interface{}{map[string]interface{}{"host": "db02", "port": "five"}, "db03"},
+			"matrix":   []interface{}{[]interface{}{"a", "b"}},
+			"empty":    map[string]interface{}{},
+		},
+	}
+	env := Env{Data: data, Indent: 4}
+
+	out, err := renderer.Render(env)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `production:
+    adapter: postgresql
+    empty: {}
+    matrix:
+    -   -   a
+        -   b
+    replicas:
+    -   host: db02
+        port: five
+    -   db03
+`)
+
+	indentSequences = true
+	out, err = renderer.Render(env)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(out), `production:
+    adapter: postgresql
+    empty: {}
+    matrix:
+        -   -   a
+            -   b
+    replicas:
+        -   host: db02
+            port: five
+        -   db03
+`)
+
+	env.Indent = 1
+	_, err = renderer.Render(env)
+	assert.Equal(t, err.Error(), "YAML can only be indented by 2 to 9 spaces, not 1")
+}